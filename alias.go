@@ -0,0 +1,117 @@
+package sled
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// Alias points to a live index, which can be replaced by a freshly built one without interrupting searches
+type Alias struct {
+	mu    sync.RWMutex
+	index *Index
+}
+
+// NewAlias points to idx; with a nil idx, searches fail with ErrClosed until the first Swap or Rebuild
+func NewAlias(idx *Index) *Alias {
+	return &Alias{index: idx}
+}
+
+// Index the alias currently points to; use it to write to the live index
+func (a *Alias) Index() *Index {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.index
+}
+
+// Swap points the alias to idx, then closes the previous index once its running operations are done.
+// with purge, the saved paths of the previous index are removed as well
+func (a *Alias) Swap(idx *Index, purge bool) error {
+	a.mu.Lock()
+	old := a.index
+	a.index = idx
+	a.mu.Unlock()
+	if old == nil || old == idx {
+		return nil
+	}
+	if purge {
+		return old.Purge()
+	}
+	if err := old.Close(); err != nil && !errors.Is(err, ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Rebuild creates a new index with ic, builds it with fn and swaps to it, while searches keep using the current index.
+// ic needs a ShardPath without an index, so rebuilding in place or into existing data fails.
+// if fn fails, the new index is purged and the alias is unchanged
+func (a *Alias) Rebuild(ic IndexConfig, fn func(idx *Index) error, purge bool) error {
+	if ic.ShardPath != "" {
+		_, err := os.Stat(getManifestPath(ic.ShardPath))
+		if err == nil {
+			return fmt.Errorf("an index exists at %q, rebuild into a new ShardPath", ic.ShardPath)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	idx, err := NewIndex(ic)
+	if err != nil {
+		return err
+	}
+	if err := fn(idx); err != nil {
+		if purgeErr := idx.Purge(); purgeErr != nil {
+			slog.Warn("failed purging rebuilt index", "error", purgeErr)
+		}
+		return err
+	}
+	return a.Swap(idx, purge)
+}
+
+func (a *Alias) Search(ctx context.Context, query string, sc *SearchConfig) (SearchResult, error) {
+	return withAlias(a, func(idx *Index) (SearchResult, error) {
+		return idx.Search(ctx, query, sc)
+	})
+}
+
+func (a *Alias) Get(ctx context.Context, id string) (Hit, error) {
+	return withAlias(a, func(idx *Index) (Hit, error) {
+		return idx.Get(ctx, id)
+	})
+}
+
+func (a *Alias) MultiGet(ctx context.Context, ids []string) ([]Hit, error) {
+	return withAlias(a, func(idx *Index) ([]Hit, error) {
+		return idx.MultiGet(ctx, ids)
+	})
+}
+
+// Close the index the alias points to
+func (a *Alias) Close() error {
+	idx := a.Index()
+	if idx == nil {
+		return ErrClosed
+	}
+	return idx.Close()
+}
+
+// run fn on the current index; if it was swapped and closed in the meantime, run it again on the new one
+func withAlias[T any](a *Alias, fn func(idx *Index) (T, error)) (T, error) {
+	for {
+		idx := a.Index()
+		if idx == nil {
+			var zero T
+			return zero, ErrClosed
+		}
+		ret, err := fn(idx)
+		if errors.Is(err, ErrClosed) && a.Index() != idx {
+			continue
+		}
+		return ret, err
+	}
+}
@@ -0,0 +1,85 @@
+package sled
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/foomo/bluge-sled/analyzer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlias(t *testing.T) {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	dir := t.TempDir()
+	ic := NewDefaultIndexConfig("test", "id", false, *ac)
+	ic.ShardPath = filepath.Join(dir, "blue")
+	blue, err := NewIndex(ic)
+	require.NoError(t, err)
+	require.NoError(t, blue.BatchInsert([]map[string]any{{"id": "a", "title": "shirt"}}))
+	alias := NewAlias(blue)
+	t.Cleanup(func() { _ = alias.Close() })
+
+	// the live index cannot be rebuilt in place
+	require.Error(t, alias.Rebuild(ic, func(idx *Index) error { return nil }, true))
+	assert.Same(t, blue, alias.Index())
+	hit, err := alias.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", hit.Id)
+
+	// nor into the data of another index
+	ic.ShardPath = filepath.Join(dir, "other")
+	other, err := NewIndex(ic)
+	require.NoError(t, err)
+	require.NoError(t, other.BatchInsert([]map[string]any{{"id": "c", "title": "shirt"}}))
+	require.NoError(t, other.Close())
+	require.Error(t, alias.Rebuild(ic, func(idx *Index) error { return fmt.Errorf("ingest failed") }, true))
+	other, err = OpenIndex(ic.ShardPath)
+	require.NoError(t, err)
+	hit, err = other.Get(context.Background(), "c")
+	require.NoError(t, err)
+	assert.Equal(t, "c", hit.Id)
+	require.NoError(t, other.Close())
+
+	ic.ShardPath = filepath.Join(dir, "blue-green")
+	require.Error(t, alias.Rebuild(ic, func(idx *Index) error {
+		return fmt.Errorf("ingest failed")
+	}, true))
+	assert.Same(t, blue, alias.Index())
+
+	require.NoError(t, alias.Rebuild(ic, func(idx *Index) error {
+		// the current index keeps serving while building
+		res, err := alias.Search(context.Background(), "shirt", newTestSearchConfig())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a"}, hitIds(res.Hits))
+		return idx.BatchInsert([]map[string]any{{"id": "a", "title": "shirt"}, {"id": "b", "title": "shirt"}})
+	}, true))
+	res, err := alias.Search(context.Background(), "shirt", newTestSearchConfig())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, hitIds(res.Hits))
+	_, err = blue.Search(context.Background(), "shirt", newTestSearchConfig())
+	assert.ErrorIs(t, err, ErrClosed)
+	_, err = os.Stat(getShardPath(filepath.Join(dir, "blue"), 0))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAliasWithoutIndex(t *testing.T) {
+	alias := NewAlias(nil)
+	_, err := alias.Search(context.Background(), "shirt", newTestSearchConfig())
+	assert.ErrorIs(t, err, ErrClosed)
+	_, err = alias.Get(context.Background(), "a")
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorIs(t, alias.Close(), ErrClosed)
+
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	require.NoError(t, alias.Rebuild(NewDefaultIndexConfig("test", "id", true, *ac), func(idx *Index) error {
+		return idx.BatchInsert([]map[string]any{{"id": "a", "title": "shirt"}})
+	}, true))
+	t.Cleanup(func() { _ = alias.Close() })
+	hit, err := alias.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", hit.Id)
+}
@@ -2,6 +2,7 @@ package sled
 
 import (
 	"path/filepath"
+	"time"
 
 	"github.com/foomo/bluge-sled/analyzer"
 )
@@ -12,11 +13,16 @@ type Config struct {
 }
 
 type IndexConfig struct {
-	ShardNum       int                `yaml:"shard_num,omitempty" json:"shard_num,omitempty"`             // number of shards to use
-	ShardPath      string             `yaml:"shard_path,omitempty" json:"shard_path,omitempty"`           // filepath to store shard index (if not in-memory)
-	IdField        string             `yaml:"id_field,omitempty" json:"id_field,omitempty"`               // data field to be used as doc _id
-	StoreFields    []string           `yaml:"store_fields,omitempty" json:"store_fields,omitempty"`       // fields to be stored in index; if not set, just use composite "_all"
-	AnalyzerConfig analyzer.ConfigMap `yaml:"analyzer_config,omitempty" json:"analyzer_config,omitempty"` // analyzer config to use per field. use "*" for any field
+	ShardNum        int                `yaml:"shard_num,omitempty" json:"shard_num,omitempty"`               // number of shards to use
+	ShardPath       string             `yaml:"shard_path,omitempty" json:"shard_path,omitempty"`             // filepath to store shard index (if not in-memory)
+	IdField         string             `yaml:"id_field,omitempty" json:"id_field,omitempty"`                 // data field to be used as doc _id
+	StoreFields     []string           `yaml:"store_fields,omitempty" json:"store_fields,omitempty"`         // fields to be stored in index; if not set, just use composite "_all"
+	AggregateFields []string           `yaml:"aggregate_fields,omitempty" json:"aggregate_fields,omitempty"` // fields to additionally index untokenized for terms facets
+	SortFields      []string           `yaml:"sort_fields,omitempty" json:"sort_fields,omitempty"`           // fields to additionally index as a single sortable value; numeric fields are sortable anyway
+	Mapping         Mapping            `yaml:"mapping,omitempty" json:"mapping,omitempty"`                   // explicit field types; unmapped fields are inferred from their values
+	AnalyzerConfig  analyzer.ConfigMap `yaml:"analyzer_config,omitempty" json:"analyzer_config,omitempty"`   // analyzer config to use per field. use "*" for any field
+	RefreshInterval time.Duration      `yaml:"refresh_interval,omitempty" json:"refresh_interval,omitempty"` // min time until written documents are visible to searches; if not set, the next search sees them. see Index.Refresh
+	TermPositions   bool               `yaml:"term_positions,omitempty" json:"term_positions,omitempty"`     // index positions of text terms, so query string phrases match their terms in order; changing it requires a reindex
 }
 
 // index config with opinionated defaults
@@ -38,12 +44,20 @@ func NewDefaultIndexConfig(name, idField string, inMemory bool, ac analyzer.Conf
 type SearchConfig struct {
 	Limit                    int                `yaml:"limit,omitempty" json:"limit,omitempty"`                                             // limit number of results returned; 0 will return all
 	From                     int                `yaml:"from,omitempty" json:"from,omitempty"`                                               // offset for paging results (to be used with limit)
+	SearchAfter              string             `yaml:"search_after,omitempty" json:"search_after,omitempty"`                               // cursor from SearchResult.NextCursor to continue after; From is ignored if set
+	SortBy                   []SortKey          `yaml:"sort_by,omitempty" json:"sort_by,omitempty"`                                         // order of results; if not set, sort by score
 	SearchFields             []string           `yaml:"search_fields,omitempty" json:"search_fields,omitempty"`                             // fields to search the query; if not set, search composite "_all"
 	ReturnFields             []string           `yaml:"return_fields,omitempty" json:"return_fields,omitempty"`                             // stored fields to return when getting search results; see IndexConfig.StoreFields to manage fields youre storing
 	QueryConfig              QueryConfig        `yaml:"query_config,omitempty" json:"query_config,omitempty"`                               // this will have no effect if SearchConfig.SearchFields are not set
 	ScoreThreshold           float64            `yaml:"score_threshold,omitempty" json:"score_threshold,omitempty"`                         // filter results below specified score. if not set, includes all
 	MaxScorePercentThreshold float64            `yaml:"max_score_percent_threshold,omitempty" json:"max_score_percent_threshold,omitempty"` // filter results below specified percent of max score.
 	AnalyzerConfig           analyzer.ConfigMap `yaml:"analyzer_config,omitempty" json:"analyzer_config,omitempty"`                         // analyzer config to use per field. use "*" for any field
+	Filters                  []Filter           `yaml:"filters,omitempty" json:"filters,omitempty"`                                         // restrict results without affecting score; all filters have to match
+	Facets                   map[string]Facet   `yaml:"facets,omitempty" json:"facets,omitempty"`                                           // aggregations over all matches by name, returned in SearchResult.Facets
+	Highlight                *HighlightConfig   `yaml:"highlight,omitempty" json:"highlight,omitempty"`                                     // return fragments of stored fields with marked query terms in Hit.Highlights
+	Timeout                  time.Duration      `yaml:"timeout,omitempty" json:"timeout,omitempty"`                                         // max duration of a search; shards not done by then fail with context.DeadlineExceeded
+	AllowPartialResults      bool               `yaml:"allow_partial_results,omitempty" json:"allow_partial_results,omitempty"`             // skip failed or timed out shards instead of failing the search; see SearchResult.ShardErrors
+	QueryString              *QueryStringConfig `yaml:"query_string,omitempty" json:"query_string,omitempty"`                               // parse the query with the syntax of QueryStringConfig; if not set, the query is searched as plain text
 }
 
 // search config with opinionated defaults
@@ -0,0 +1,202 @@
+package sled
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type CSVConfig struct {
+	Comma      rune                 // column delimiter; defaults to ','
+	Quote      rune                 // quote character of columns; defaults to '"'
+	LazyQuotes bool                 // allow quotes in unquoted columns and single quotes in quoted columns
+	Header     []string             // column names; if not set, the first row is used
+	Columns    map[string]string    // rename columns to field names; columns mapped to "-" are skipped
+	Types      map[string]FieldType // coerce fields to numeric, bool or date; defaults to the IndexConfig.Mapping type, strings otherwise
+	DateLayout string               // layout of date fields; defaults to RFC3339 or "2006-01-02"
+	Split      map[string]string    // split values of fields with multiple values by a separator
+}
+
+// coerce columns to the types of mapped fields, unless configured otherwise
+func (cc CSVConfig) withMapping(m Mapping) CSVConfig {
+	types := maps.Clone(cc.Types)
+	if types == nil {
+		types = map[string]FieldType{}
+	}
+	for name, fm := range m {
+		if _, ok := types[name]; !ok {
+			types[name] = fm.Type
+		}
+	}
+	cc.Types = types
+	return cc
+}
+
+// call fn for every row with the columns as fields; empty columns are omitted
+func decodeCSV(r io.Reader, cc CSVConfig, fn func(n int, datum map[string]any, err error) error) error {
+	var unquote func([]string)
+	if cc.Quote != 0 && cc.Quote != '"' {
+		if cc.Quote == cmp.Or(cc.Comma, ',') || cc.Quote == '\r' || cc.Quote == '\n' || !utf8.ValidRune(cc.Quote) || cc.Quote == utf8.RuneError {
+			return fmt.Errorf("invalid csv quote %q", cc.Quote)
+		}
+		// encoding/csv only knows '"', so the quote is swapped with it while reading and back in the columns
+		qr := &quoteReader{r: bufio.NewReader(r), quote: cc.Quote}
+		r = qr
+		unquote = func(row []string) {
+			for n, column := range row {
+				row[n] = strings.Map(qr.swap, column)
+			}
+		}
+	}
+	cr := csv.NewReader(r)
+	if cc.Comma != 0 {
+		cr.Comma = cc.Comma
+	}
+	cr.LazyQuotes = cc.LazyQuotes
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	header := cc.Header
+	if len(header) == 0 {
+		row, err := cr.Read()
+		if err != nil {
+			return fmt.Errorf("failed to read csv header: %w", err)
+		}
+		if unquote != nil {
+			unquote(row)
+		}
+		header = make([]string, len(row))
+		for n, column := range row {
+			header[n] = strings.TrimSpace(column)
+		}
+	}
+	fields := make([]string, len(header))
+	for n, column := range header {
+		fields[n] = column
+		if name, ok := cc.Columns[column]; ok {
+			fields[n] = name
+		}
+	}
+	for n := 0; ; n++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			if err := fn(n, nil, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if unquote != nil {
+			unquote(row)
+		}
+		datum, err := newCSVDatum(row, fields, cc)
+		if err := fn(n, datum, err); err != nil {
+			return err
+		}
+	}
+}
+
+// quoteReader swaps a quote character with '"' and vice versa
+type quoteReader struct {
+	r     *bufio.Reader
+	quote rune
+}
+
+func (qr *quoteReader) Read(p []byte) (n int, err error) {
+	if len(p) < utf8.UTFMax {
+		return 0, io.ErrShortBuffer
+	}
+	// stop at the end of the buffered input to not block on a stream
+	for n+utf8.UTFMax <= len(p) && (n == 0 || qr.r.Buffered() > 0) {
+		r, size, err := qr.r.ReadRune()
+		if err != nil {
+			return n, err
+		}
+		if r == utf8.RuneError && size == 1 {
+			// keep invalid bytes as they are
+			_ = qr.r.UnreadRune()
+			p[n], _ = qr.r.ReadByte()
+			n++
+			continue
+		}
+		n += utf8.EncodeRune(p[n:], qr.swap(r))
+	}
+	return n, nil
+}
+
+func (qr *quoteReader) swap(r rune) rune {
+	switch r {
+	case qr.quote:
+		return '"'
+	case '"':
+		return qr.quote
+	}
+	return r
+}
+
+func newCSVDatum(row, fields []string, cc CSVConfig) (map[string]any, error) {
+	if len(row) != len(fields) {
+		return nil, fmt.Errorf("expected %d columns, got %d", len(fields), len(row))
+	}
+	datum := make(map[string]any, len(fields))
+	for n, field := range fields {
+		if field == "-" || row[n] == "" {
+			continue
+		}
+		if sep, ok := cc.Split[field]; ok {
+			var values []any
+			for _, s := range strings.Split(row[n], sep) {
+				if s = strings.TrimSpace(s); s == "" {
+					continue
+				}
+				value, err := cc.coerce(field, s)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+			datum[field] = values
+			continue
+		}
+		value, err := cc.coerce(field, row[n])
+		if err != nil {
+			return nil, err
+		}
+		datum[field] = value
+	}
+	return datum, nil
+}
+
+func (cc CSVConfig) coerce(field, s string) (value any, err error) {
+	switch cc.Types[field] {
+	case NumericFieldType:
+		value, err = strconv.ParseFloat(strings.TrimSpace(s), 64)
+	case BoolFieldType:
+		value, err = strconv.ParseBool(strings.TrimSpace(s))
+	case DateFieldType:
+		if cc.DateLayout != "" {
+			value, err = time.Parse(cc.DateLayout, strings.TrimSpace(s))
+		} else {
+			value, err = toTime(strings.TrimSpace(s))
+		}
+	default:
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("column %q: %w", field, err)
+	}
+	return value, nil
+}
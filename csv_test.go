@@ -0,0 +1,92 @@
+package sled
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foomo/bluge-sled/analyzer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCSV(t *testing.T) {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	ic := NewDefaultIndexConfig("test", "sku", true, *ac)
+	ic.StoreFields = []string{"*"}
+	ic.Mapping = Mapping{"released": {Type: DateFieldType}}
+	idx, err := NewIndex(ic)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = idx.Close() })
+	input := `SKU;title;price;in stock;released;tags
+a;"red; shirt";19.5;true;2024-02-01;summer|sale
+b;blue shirt;cheap;false;2024-02-01;
+c;green shirt;10;1;;winter
+`
+	report, err := idx.Load(context.Background(), strings.NewReader(input), CSVFormat, &LoadConfig{
+		CSV: CSVConfig{
+			Comma:   ';',
+			Columns: map[string]string{"SKU": "sku", "in stock": "in_stock"},
+			Types:   map[string]FieldType{"price": NumericFieldType, "in_stock": BoolFieldType},
+			Split:   map[string]string{"tags": "|"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, LoadProgress{Read: 3, Loaded: 2, Failed: 1}, report.LoadProgress)
+	require.Len(t, report.Errors, 1)
+	assert.Equal(t, 1, report.Errors[0].Record)
+
+	sc := newTestSearchConfig()
+	sc.ReturnFields = []string{"title", "price", "in_stock", "released", "tags"}
+	sc.SortBy = []SortKey{{Field: "price"}}
+	res, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "a"}, hitIds(res.Hits))
+	assert.Equal(t, map[string][]any{
+		"title":    {"green shirt"},
+		"price":    {10.0},
+		"in_stock": {true},
+		"tags":     {"winter"},
+	}, res.Hits[0].Fields)
+	assert.Equal(t, map[string][]any{
+		"title":    {"red; shirt"},
+		"price":    {19.5},
+		"in_stock": {true},
+		"released": {time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		"tags":     {"summer", "sale"},
+	}, res.Hits[1].Fields)
+}
+
+func TestLoadCSVQuote(t *testing.T) {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	ic := NewDefaultIndexConfig("test", "sku", true, *ac)
+	ic.StoreFields = []string{"*"}
+	idx, err := NewIndex(ic)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = idx.Close() })
+	input := `'sku';title;info
+a;'red; ''shirt''';"cotton" tee
+b;blue shirt;'multi
+line'
+`
+	report, err := idx.Load(context.Background(), strings.NewReader(input), CSVFormat, &LoadConfig{
+		CSV: CSVConfig{Comma: ';', Quote: '\''},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, LoadProgress{Read: 2, Loaded: 2}, report.LoadProgress)
+
+	sc := newTestSearchConfig()
+	sc.ReturnFields = []string{"title", "info"}
+	sc.SortBy = []SortKey{{Field: "_id"}}
+	res, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, hitIds(res.Hits))
+	assert.Equal(t, map[string][]any{"title": {"red; 'shirt'"}, "info": {`"cotton" tee`}}, res.Hits[0].Fields)
+	assert.Equal(t, map[string][]any{"title": {"blue shirt"}, "info": {"multi\nline"}}, res.Hits[1].Fields)
+
+	_, err = idx.Load(context.Background(), strings.NewReader(input), CSVFormat, &LoadConfig{
+		CSV: CSVConfig{Quote: ','},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,53 @@
+package sled
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// cursor points behind the last hit of a page; encoded opaque for clients
+type cursor struct {
+	Score     float64  `json:"s"`
+	Shard     int      `json:"h"`
+	Id        string   `json:"i"`
+	SortValue [][]byte `json:"v"`
+}
+
+func newCursor(hit Hit, shardNum int) string {
+	c := cursor{
+		Score:     hit.Score,
+		Shard:     getShardId(shardNum, hit.Id),
+		Id:        hit.Id,
+		SortValue: hit.sortValue,
+	}
+	// encoding a struct of basic types cannot fail
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string, shardNum, sortKeys int) (c cursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if c.Shard != getShardId(shardNum, c.Id) {
+		return c, fmt.Errorf("invalid cursor: not created for an index with %d shards", shardNum)
+	}
+	if len(c.SortValue) != sortKeys {
+		return c, fmt.Errorf("invalid cursor: not created for the current sort order")
+	}
+	return c, nil
+}
+
+// order hits the same way the shards sorted them
+func compareHits(order search.SortOrder) func(a, b Hit) int {
+	return func(a, b Hit) int {
+		return order.Compare(&search.DocumentMatch{SortValue: a.sortValue}, &search.DocumentMatch{SortValue: b.sortValue})
+	}
+}
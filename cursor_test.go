@@ -0,0 +1,34 @@
+package sled
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchAfter(t *testing.T) {
+	idx := newTestIndex(t, 4)
+	sc := newTestSearchConfig()
+	all, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	require.ElementsMatch(t, testDocIds(func(n int) bool { return true }), hitIds(all.Hits))
+
+	var paged []Hit
+	sc.Limit = 6
+	for {
+		res, err := idx.Search(context.Background(), "shirt", sc)
+		require.NoError(t, err)
+		paged = append(paged, res.Hits...)
+		if res.NextCursor == "" {
+			break
+		}
+		sc.SearchAfter = res.NextCursor
+	}
+	assert.Equal(t, all.Hits, paged)
+
+	sc.SearchAfter = "invalid"
+	_, err = idx.Search(context.Background(), "shirt", sc)
+	assert.Error(t, err)
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
+	"html"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	sled "github.com/foomo/bluge-sled"
 	"github.com/foomo/bluge-sled/analyzer"
@@ -32,26 +35,46 @@ func main() {
 	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer).WithLength(3, 15)
 	ic := sled.NewDefaultIndexConfig("fake", "id", false, *ac)
 	ic.StoreFields = []string{"title", "description", "brand", "color"}
+	ic.AggregateFields = []string{"color"}
 	sc := sled.NewDefaultSearchConfig(*ac, []string{"title", "description", "brand", "color"})
+	sc.Facets = map[string]sled.Facet{
+		"color": {Terms: &sled.TermsFacet{Field: "color"}},
+	}
 
-	bi, err := loadIndex(*flagDataPath, ic, *flagReload)
+	bi, err := openIndex(ic)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// searches keep using the current index while the new one is loaded
+	alias := sled.NewAlias(bi)
+	if *flagReload {
+		go func() {
+			if err := reloadIndex(alias, *flagDataPath, ic); err != nil {
+				slog.Error("reload failed", "error", err)
+			}
+		}()
+	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		q := r.URL.Query().Get("q")
-		res, err := bi.Search(r.Context(), q, &sc)
+		res, err := alias.Search(r.Context(), q, &sc)
 		if err != nil {
 			slog.Error(err.Error())
 			return
 		}
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		for _, bucket := range res.Facets["color"] {
+			fmt.Fprintf(w, "<span style=\"margin: 5px\">%s (%d)</span>", html.EscapeString(bucket.Key), bucket.Count)
+		}
 		for _, hit := range res.Hits {
+			var description []string
+			for _, v := range hit.Fields["description"] {
+				description = append(description, fmt.Sprint(v))
+			}
 			component := item.Item(
 				"",
 				hit.Values["title"],
-				hit.Values["description"],
+				strings.Join(description, " "),
 				hit.Values["brand"],
 				hit.Values["color"])
 			component.Render(r.Context(), w)
@@ -62,36 +85,46 @@ func main() {
 	http.ListenAndServe(addr, nil)
 }
 
-func loadIndex(dataPath string, ic sled.IndexConfig, reload bool) (*sled.Index, error) {
-	if reload {
-		if ic.ShardPath == "" {
-			return nil, fmt.Errorf("cannot reload if IndexConfig.ShardPath is empty")
-		}
-		paths, err := filepath.Glob(ic.ShardPath + "*/*")
-		if err != nil {
-			return nil, err
-		}
-		for _, path := range paths {
-			os.RemoveAll(path)
-		}
+// every load creates a new index next to the previous ones, the newest is opened on start
+func getIndexPath(basePath string) string {
+	return basePath + "-v" + time.Now().Format("20060102150405.000000")
+}
+
+func openIndex(ic sled.IndexConfig) (*sled.Index, error) {
+	if ic.ShardPath == "" {
+		return nil, fmt.Errorf("cannot open an index if IndexConfig.ShardPath is empty")
 	}
-	bi, err := sled.NewIndex(ic)
+	manifests, err := filepath.Glob(ic.ShardPath + "-v*-manifest.json")
 	if err != nil {
 		return nil, err
 	}
-	if reload {
-		f, err := os.Open(dataPath)
+	if len(manifests) > 0 {
+		return sled.OpenIndex(strings.TrimSuffix(manifests[len(manifests)-1], "-manifest.json"))
+	}
+	ic.ShardPath = getIndexPath(ic.ShardPath)
+	return sled.NewIndex(ic)
+}
+
+// load the data into a new index and swap the alias to it, removing the previous index
+func reloadIndex(alias *sled.Alias, dataPath string, ic sled.IndexConfig) error {
+	f, err := os.Open(dataPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ic.ShardPath = getIndexPath(ic.ShardPath)
+	return alias.Rebuild(ic, func(idx *sled.Index) error {
+		report, err := idx.Load(context.Background(), f, sled.JSONFormat, &sled.LoadConfig{
+			Progress: func(p sled.LoadProgress) {
+				slog.Debug("loading", "read", p.Read, "loaded", p.Loaded, "failed", p.Failed)
+			},
+		})
 		if err != nil {
-			return nil, err
+			return err
 		}
-		defer f.Close()
-		var data []map[string]interface{}
-		if err := json.NewDecoder(f).Decode(&data); err != nil {
-			return nil, err
+		for _, err := range report.Errors {
+			slog.Warn("skipped record", "error", err)
 		}
-		if err := bi.BatchInsert(data); err != nil {
-			return nil, err
-		}
-	}
-	return bi, nil
+		return nil
+	}, true)
 }
@@ -0,0 +1,300 @@
+package sled
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/aggregations"
+)
+
+// Facet describes an aggregation computed over all matching documents.
+// exactly one facet type has to be set
+type Facet struct {
+	Terms         *TermsFacet         `yaml:"terms,omitempty" json:"terms,omitempty"`                   // count distinct values of a field; see IndexConfig.AggregateFields
+	Range         *RangeFacet         `yaml:"range,omitempty" json:"range,omitempty"`                   // count numeric values within ranges
+	DateHistogram *DateHistogramFacet `yaml:"date_histogram,omitempty" json:"date_histogram,omitempty"` // count dates per interval
+}
+
+type TermsFacet struct {
+	Field string `yaml:"field" json:"field"`
+	Size  int    `yaml:"size,omitempty" json:"size,omitempty"` // number of buckets to return; defaults to 10
+}
+
+type RangeFacet struct {
+	Field  string       `yaml:"field" json:"field"`
+	Ranges []FacetRange `yaml:"ranges" json:"ranges"`
+}
+
+// FacetRange includes From and excludes To; unset bounds are open
+type FacetRange struct {
+	Name string   `yaml:"name,omitempty" json:"name,omitempty"`
+	From *float64 `yaml:"from,omitempty" json:"from,omitempty"`
+	To   *float64 `yaml:"to,omitempty" json:"to,omitempty"`
+}
+
+type DateHistogramFacet struct {
+	Field    string `yaml:"field" json:"field"`
+	Interval string `yaml:"interval" json:"interval"` // "day", "week", "month", "year" or a duration like "6h"
+}
+
+type FacetBucket struct {
+	Key   string
+	Count uint64
+}
+
+const defaultTermsFacetSize = 10
+
+type aggregator interface {
+	AddAggregation(name string, aggregation search.Aggregation)
+}
+
+// register facets as aggregations on a shard search request
+func addFacets(req aggregator, facets map[string]Facet, shardNum int) error {
+	for name, f := range facets {
+		agg, err := f.aggregation(shardNum)
+		if err != nil {
+			return fmt.Errorf("invalid facet %q: %w", name, err)
+		}
+		req.AddAggregation(facetAggregationName(name), agg)
+	}
+	return nil
+}
+
+// prefix facets so they do not collide with the standard aggregations
+func facetAggregationName(name string) string {
+	return "facet:" + name
+}
+
+func (f Facet) aggregation(shardNum int) (search.Aggregation, error) {
+	var n int
+	for _, set := range []bool{f.Terms != nil, f.Range != nil, f.DateHistogram != nil} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return nil, fmt.Errorf("facet must have exactly one type, got %d", n)
+	}
+	switch {
+	case f.Terms != nil:
+		if f.Terms.Field == "" {
+			return nil, fmt.Errorf("terms facet requires a field")
+		}
+		return aggregations.NewTermsAggregation(search.Field(keywordField(f.Terms.Field)), f.Terms.shardSize(shardNum)), nil
+	case f.Range != nil:
+		if f.Range.Field == "" {
+			return nil, fmt.Errorf("range facet requires a field")
+		}
+		agg := aggregations.Ranges(search.Field(f.Range.Field))
+		for _, r := range f.Range.Ranges {
+			from, to := math.Inf(-1), math.Inf(1)
+			if r.From != nil {
+				from = *r.From
+			}
+			if r.To != nil {
+				to = *r.To
+			}
+			agg.AddRange(aggregations.NamedRange(r.key(), from, to))
+		}
+		return agg, nil
+	default:
+		if f.DateHistogram.Field == "" {
+			return nil, fmt.Errorf("date histogram facet requires a field")
+		}
+		if _, err := parseInterval(f.DateHistogram.Interval); err != nil {
+			return nil, err
+		}
+		return &dateHistogramAggregation{src: search.Field(f.DateHistogram.Field), interval: f.DateHistogram.Interval}, nil
+	}
+}
+
+func (tf TermsFacet) size() int {
+	if tf.Size <= 0 {
+		return defaultTermsFacetSize
+	}
+	return tf.Size
+}
+
+// over-fetch terms per shard, so the merged top terms are more accurate
+func (tf TermsFacet) shardSize(shardNum int) int {
+	if shardNum <= 1 {
+		return tf.size()
+	}
+	return tf.size()*3/2 + 10
+}
+
+func (r FacetRange) key() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	from, to := "*", "*"
+	if r.From != nil {
+		from = strconv.FormatFloat(*r.From, 'f', -1, 64)
+	}
+	if r.To != nil {
+		to = strconv.FormatFloat(*r.To, 'f', -1, 64)
+	}
+	return from + "-" + to
+}
+
+// read the facet buckets of a single shard
+func getFacets(b *search.Bucket, facets map[string]Facet) map[string][]FacetBucket {
+	if len(facets) == 0 {
+		return nil
+	}
+	res := make(map[string][]FacetBucket, len(facets))
+	for name := range facets {
+		for _, bucket := range b.Buckets(facetAggregationName(name)) {
+			res[name] = append(res[name], FacetBucket{Key: bucket.Name(), Count: bucket.Count()})
+		}
+	}
+	return res
+}
+
+// merge facet buckets of several shards by summing up the counts per key
+func mergeFacets(shardFacets []map[string][]FacetBucket, facets map[string]Facet) map[string][]FacetBucket {
+	if len(facets) == 0 {
+		return nil
+	}
+	res := make(map[string][]FacetBucket, len(facets))
+	for name, f := range facets {
+		var keys []string
+		counts := map[string]uint64{}
+		for _, sf := range shardFacets {
+			for _, bucket := range sf[name] {
+				if _, ok := counts[bucket.Key]; !ok {
+					keys = append(keys, bucket.Key)
+				}
+				counts[bucket.Key] += bucket.Count
+			}
+		}
+		buckets := make([]FacetBucket, 0, len(keys))
+		for _, key := range keys {
+			buckets = append(buckets, FacetBucket{Key: key, Count: counts[key]})
+		}
+		switch {
+		case f.Terms != nil:
+			slices.SortFunc(buckets, func(a, b FacetBucket) int {
+				if a.Count != b.Count {
+					if a.Count > b.Count {
+						return -1
+					}
+					return 1
+				}
+				return strings.Compare(a.Key, b.Key)
+			})
+			if len(buckets) > f.Terms.size() {
+				buckets = buckets[:f.Terms.size()]
+			}
+		case f.DateHistogram != nil:
+			// keys are RFC3339 formatted and sort chronologically
+			slices.SortFunc(buckets, func(a, b FacetBucket) int {
+				return strings.Compare(a.Key, b.Key)
+			})
+		}
+		// range buckets keep their configured order
+		res[name] = buckets
+	}
+	return res
+}
+
+func parseInterval(interval string) (func(time.Time) time.Time, error) {
+	switch interval {
+	case "day":
+		return func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		}, nil
+	case "week":
+		return func(t time.Time) time.Time {
+			// weeks start on monday
+			d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+			return d.AddDate(0, 0, -(int(d.Weekday())+6)%7)
+		}, nil
+	case "month":
+		return func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		}, nil
+	case "year":
+		return func(t time.Time) time.Time {
+			return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		}, nil
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid date histogram interval %q", interval)
+	}
+	return func(t time.Time) time.Time {
+		return t.Truncate(d)
+	}, nil
+}
+
+// dateHistogramAggregation buckets dates by a fixed or calendar interval
+type dateHistogramAggregation struct {
+	src      search.DateValuesSource
+	interval string
+}
+
+func (a *dateHistogramAggregation) Fields() []string {
+	return a.src.Fields()
+}
+
+func (a *dateHistogramAggregation) Calculator() search.Calculator {
+	// interval was validated when creating the aggregation
+	truncate, _ := parseInterval(a.interval)
+	return &dateHistogramCalculator{
+		src:        a.src,
+		truncate:   truncate,
+		bucketsMap: make(map[string]*search.Bucket),
+	}
+}
+
+type dateHistogramCalculator struct {
+	src         search.DateValuesSource
+	truncate    func(time.Time) time.Time
+	bucketsList []*search.Bucket
+	bucketsMap  map[string]*search.Bucket
+}
+
+func (c *dateHistogramCalculator) Consume(d *search.DocumentMatch) {
+	for _, dt := range c.src.Dates(d) {
+		key := c.truncate(dt.UTC()).Format(time.RFC3339)
+		bucket, ok := c.bucketsMap[key]
+		if !ok {
+			bucket = search.NewBucket(key, map[string]search.Aggregation{
+				"count": aggregations.CountMatches(),
+			})
+			c.bucketsMap[key] = bucket
+			c.bucketsList = append(c.bucketsList, bucket)
+		}
+		bucket.Consume(d)
+	}
+}
+
+func (c *dateHistogramCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*dateHistogramCalculator); ok {
+		for _, ob := range other.bucketsList {
+			if bucket, ok := c.bucketsMap[ob.Name()]; ok {
+				bucket.Merge(ob)
+				continue
+			}
+			c.bucketsMap[ob.Name()] = ob
+			c.bucketsList = append(c.bucketsList, ob)
+		}
+		c.Finish()
+	}
+}
+
+func (c *dateHistogramCalculator) Finish() {
+	slices.SortFunc(c.bucketsList, func(a, b *search.Bucket) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+}
+
+func (c *dateHistogramCalculator) Buckets() []*search.Bucket {
+	return c.bucketsList
+}
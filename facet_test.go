@@ -0,0 +1,39 @@
+package sled
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchFacets(t *testing.T) {
+	idx := newTestIndex(t, 3)
+	sc := newTestSearchConfig()
+	sc.Filters = []Filter{{Range: &RangeFilter{Field: "price", Lt: ptr(10.0)}}}
+	sc.Facets = map[string]Facet{
+		"brand":    {Terms: &TermsFacet{Field: "brand", Size: 1}},
+		"in_stock": {Terms: &TermsFacet{Field: "in_stock"}},
+		"price": {Range: &RangeFacet{Field: "price", Ranges: []FacetRange{
+			{Name: "cheap", To: ptr(5.0)},
+			{From: ptr(5.0)},
+		}}},
+		"created": {DateHistogram: &DateHistogramFacet{Field: "created", Interval: "month"}},
+	}
+	res, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	assert.Equal(t, []FacetBucket{{"acme", 5}}, res.Facets["brand"])
+	assert.Equal(t, []FacetBucket{{"false", 6}, {"true", 4}}, res.Facets["in_stock"])
+	assert.Equal(t, []FacetBucket{{"cheap", 5}, {"5-*", 5}}, res.Facets["price"])
+	assert.Equal(t, []FacetBucket{
+		{"2024-01-01T00:00:00Z", 3},
+		{"2024-02-01T00:00:00Z", 3},
+		{"2024-03-01T00:00:00Z", 2},
+		{"2024-04-01T00:00:00Z", 2},
+	}, res.Facets["created"])
+
+	sc.Facets = map[string]Facet{"invalid": {}}
+	_, err = idx.Search(context.Background(), "shirt", sc)
+	assert.Error(t, err)
+}
@@ -0,0 +1,199 @@
+package sled
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/analysis"
+)
+
+// Filter restricts search results without affecting their score.
+// exactly one clause has to be set; use And, Or and Not to combine filters
+type Filter struct {
+	Term   *TermFilter   `yaml:"term,omitempty" json:"term,omitempty"`     // field matches value
+	Terms  *TermsFilter  `yaml:"terms,omitempty" json:"terms,omitempty"`   // field matches any of the values
+	Range  *RangeFilter  `yaml:"range,omitempty" json:"range,omitempty"`   // numeric field is within range
+	Bool   *BoolFilter   `yaml:"bool,omitempty" json:"bool,omitempty"`     // boolean field equals value
+	Exists *ExistsFilter `yaml:"exists,omitempty" json:"exists,omitempty"` // field has any value
+	And    []Filter      `yaml:"and,omitempty" json:"and,omitempty"`       // all of the filters match
+	Or     []Filter      `yaml:"or,omitempty" json:"or,omitempty"`         // any of the filters match
+	Not    *Filter       `yaml:"not,omitempty" json:"not,omitempty"`       // filter does not match
+}
+
+type TermFilter struct {
+	Field string `yaml:"field" json:"field"`
+	Value string `yaml:"value" json:"value"`
+}
+
+type TermsFilter struct {
+	Field  string   `yaml:"field" json:"field"`
+	Values []string `yaml:"values" json:"values"`
+}
+
+// RangeFilter matches numeric values within the given bounds; unset bounds are open
+type RangeFilter struct {
+	Field string   `yaml:"field" json:"field"`
+	Gt    *float64 `yaml:"gt,omitempty" json:"gt,omitempty"`
+	Gte   *float64 `yaml:"gte,omitempty" json:"gte,omitempty"`
+	Lt    *float64 `yaml:"lt,omitempty" json:"lt,omitempty"`
+	Lte   *float64 `yaml:"lte,omitempty" json:"lte,omitempty"`
+}
+
+type BoolFilter struct {
+	Field string `yaml:"field" json:"field"`
+	Value bool   `yaml:"value" json:"value"`
+}
+
+type ExistsFilter struct {
+	Field string `yaml:"field" json:"field"`
+}
+
+// add filters as non-scoring clauses to the given query
+func newFilteredQuery(q bluge.Query, filters []Filter, as map[string]*analysis.Analyzer) (bluge.Query, error) {
+	if len(filters) == 0 {
+		return q, nil
+	}
+	bq := bluge.NewBooleanQuery().AddMust(q)
+	for i, f := range filters {
+		if f.Not != nil && f.clauses() == 1 {
+			fq, err := f.Not.query(as)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter at index %d: %w", i, err)
+			}
+			bq.AddMustNot(fq)
+			continue
+		}
+		fq, err := f.query(as)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter at index %d: %w", i, err)
+		}
+		// zero boost so the filter does not contribute to the score
+		bq.AddMust(bluge.NewBooleanQuery().AddMust(fq).SetBoost(0))
+	}
+	return bq, nil
+}
+
+func (f Filter) clauses() (n int) {
+	for _, set := range []bool{f.Term != nil, f.Terms != nil, f.Range != nil, f.Bool != nil, f.Exists != nil, f.And != nil, f.Or != nil, f.Not != nil} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+func (f Filter) query(as map[string]*analysis.Analyzer) (bluge.Query, error) {
+	if n := f.clauses(); n != 1 {
+		return nil, fmt.Errorf("filter must have exactly one clause, got %d", n)
+	}
+	switch {
+	case f.Term != nil:
+		if f.Term.Field == "" {
+			return nil, fmt.Errorf("term filter requires a field")
+		}
+		return newTermFilterQuery(f.Term.Field, f.Term.Value, as), nil
+	case f.Terms != nil:
+		if f.Terms.Field == "" {
+			return nil, fmt.Errorf("terms filter requires a field")
+		}
+		if len(f.Terms.Values) == 0 {
+			return bluge.NewMatchNoneQuery(), nil
+		}
+		bq := bluge.NewBooleanQuery()
+		for _, v := range f.Terms.Values {
+			bq.AddShould(newTermFilterQuery(f.Terms.Field, v, as))
+		}
+		return bq, nil
+	case f.Range != nil:
+		return f.Range.query()
+	case f.Bool != nil:
+		if f.Bool.Field == "" {
+			return nil, fmt.Errorf("bool filter requires a field")
+		}
+		return bluge.NewTermQuery(strconv.FormatBool(f.Bool.Value)).SetField(f.Bool.Field), nil
+	case f.Exists != nil:
+		if f.Exists.Field == "" {
+			return nil, fmt.Errorf("exists filter requires a field")
+		}
+		return newExistsQuery(f.Exists.Field), nil
+	case f.And != nil:
+		bq := bluge.NewBooleanQuery()
+		for _, sf := range f.And {
+			sq, err := sf.query(as)
+			if err != nil {
+				return nil, err
+			}
+			bq.AddMust(sq)
+		}
+		return bq, nil
+	case f.Or != nil:
+		bq := bluge.NewBooleanQuery()
+		for _, sf := range f.Or {
+			sq, err := sf.query(as)
+			if err != nil {
+				return nil, err
+			}
+			bq.AddShould(sq)
+		}
+		return bq, nil
+	default:
+		sq, err := f.Not.query(as)
+		if err != nil {
+			return nil, err
+		}
+		return newMustNotQuery(sq), nil
+	}
+}
+
+// documents which do not match q; a nested query with only must not clauses would match nothing
+func newMustNotQuery(q bluge.Query) bluge.Query {
+	return bluge.NewBooleanQuery().AddMust(bluge.NewMatchAllQuery()).AddMustNot(q)
+}
+
+func newExistsQuery(field string) bluge.Query {
+	// any term greater or equal the lowest possible term
+	return bluge.NewTermRangeInclusiveQuery("\x00", "", true, false).SetField(field)
+}
+
+// values are analyzed the same way as the field, so all of their terms have to match
+func newTermFilterQuery(field, value string, as map[string]*analysis.Analyzer) bluge.Query {
+	a, ok := as[field]
+	if !ok {
+		a = as["*"]
+	}
+	q := bluge.NewMatchQuery(value).
+		SetField(field).
+		SetOperator(bluge.MatchQueryOperatorAnd)
+	if a != nil {
+		q.SetAnalyzer(a)
+	}
+	return q
+}
+
+func (rf RangeFilter) query() (bluge.Query, error) {
+	if rf.Field == "" {
+		return nil, fmt.Errorf("range filter requires a field")
+	}
+	if rf.Gt != nil && rf.Gte != nil || rf.Lt != nil && rf.Lte != nil {
+		return nil, fmt.Errorf("range filter on %q has conflicting bounds", rf.Field)
+	}
+	min, minInclusive := bluge.MinNumeric, false
+	switch {
+	case rf.Gte != nil:
+		min, minInclusive = *rf.Gte, true
+	case rf.Gt != nil:
+		min = *rf.Gt
+	}
+	max, maxInclusive := bluge.MaxNumeric, false
+	switch {
+	case rf.Lte != nil:
+		max, maxInclusive = *rf.Lte, true
+	case rf.Lt != nil:
+		max = *rf.Lt
+	}
+	if min == bluge.MinNumeric && max == bluge.MaxNumeric {
+		return nil, fmt.Errorf("range filter on %q requires at least one bound", rf.Field)
+	}
+	return bluge.NewNumericRangeInclusiveQuery(min, max, minInclusive, maxInclusive).SetField(rf.Field), nil
+}
@@ -0,0 +1,83 @@
+package sled
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchFilters(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	tests := []struct {
+		name    string
+		filters []Filter
+		want    func(n int) bool
+	}{
+		{"term", []Filter{{Term: &TermFilter{Field: "brand", Value: "Acme"}}}, func(n int) bool { return n%2 == 0 }},
+		{"terms", []Filter{{Terms: &TermsFilter{Field: "brand", Values: []string{"acme", "globex"}}}}, func(n int) bool { return true }},
+		{"range", []Filter{{Range: &RangeFilter{Field: "price", Gte: ptr(10.0), Lt: ptr(15.0)}}}, func(n int) bool { return n >= 10 && n < 15 }},
+		{"bool", []Filter{{Bool: &BoolFilter{Field: "in_stock", Value: true}}}, func(n int) bool { return n%3 == 0 }},
+		{"exists", []Filter{{Exists: &ExistsFilter{Field: "price"}}}, func(n int) bool { return true }},
+		{"exists missing", []Filter{{Exists: &ExistsFilter{Field: "missing"}}}, func(n int) bool { return false }},
+		{"not", []Filter{{Not: &Filter{Term: &TermFilter{Field: "brand", Value: "acme"}}}}, func(n int) bool { return n%2 == 1 }},
+		{"and", []Filter{{And: []Filter{
+			{Term: &TermFilter{Field: "brand", Value: "acme"}},
+			{Range: &RangeFilter{Field: "price", Lte: ptr(4.0)}},
+		}}}, func(n int) bool { return n%2 == 0 && n <= 4 }},
+		{"or", []Filter{{Or: []Filter{
+			{Range: &RangeFilter{Field: "price", Lt: ptr(2.0)}},
+			{Range: &RangeFilter{Field: "price", Gt: ptr(17.0)}},
+		}}}, func(n int) bool { return n < 2 || n > 17 }},
+		{"or not", []Filter{{Or: []Filter{
+			{Not: &Filter{Term: &TermFilter{Field: "brand", Value: "acme"}}},
+			{Range: &RangeFilter{Field: "price", Lt: ptr(1.0)}},
+		}}}, func(n int) bool { return n%2 == 1 || n == 0 }},
+		{"and not", []Filter{{And: []Filter{
+			{Not: &Filter{Term: &TermFilter{Field: "brand", Value: "acme"}}},
+			{Range: &RangeFilter{Field: "price", Lte: ptr(4.0)}},
+		}}}, func(n int) bool { return n%2 == 1 && n <= 4 }},
+		{"not not", []Filter{{Not: &Filter{Not: &Filter{Term: &TermFilter{Field: "brand", Value: "acme"}}}}}, func(n int) bool { return n%2 == 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := newTestSearchConfig()
+			sc.Filters = tt.filters
+			res, err := idx.Search(context.Background(), "shirt", sc)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, testDocIds(tt.want), hitIds(res.Hits))
+		})
+	}
+}
+
+func TestSearchFiltersScore(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	sc := newTestSearchConfig()
+	sc.Filters = nil
+	res, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	scores := map[string]float64{}
+	for _, hit := range res.Hits {
+		scores[hit.Id] = hit.Score
+	}
+	sc.Filters = []Filter{
+		{Term: &TermFilter{Field: "brand", Value: "acme"}},
+		{Not: &Filter{Range: &RangeFilter{Field: "price", Gt: ptr(10.0)}}},
+	}
+	res, err = idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	require.ElementsMatch(t, testDocIds(func(n int) bool { return n%2 == 0 && n <= 10 }), hitIds(res.Hits))
+	// filters do not contribute to the score
+	for _, hit := range res.Hits {
+		assert.InDelta(t, scores[hit.Id], hit.Score, 1e-9, hit.Id)
+	}
+}
+
+func TestSearchFiltersInvalid(t *testing.T) {
+	idx := newTestIndex(t, 1)
+	sc := newTestSearchConfig()
+	sc.Filters = []Filter{{}}
+	_, err := idx.Search(context.Background(), "shirt", sc)
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package sled
+
+import (
+	"github.com/blugelabs/bluge/analysis"
+	"github.com/blugelabs/bluge/analysis/analyzer"
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/highlight"
+)
+
+// HighlightConfig marks the analyzed query terms in stored values. terms which only matched by fuzziness
+// are marked with IndexConfig.TermPositions only, as their locations are not indexed otherwise
+type HighlightConfig struct {
+	Fields       []string `yaml:"fields,omitempty" json:"fields,omitempty"`               // stored fields to highlight; if not set, use SearchConfig.ReturnFields
+	FragmentSize int      `yaml:"fragment_size,omitempty" json:"fragment_size,omitempty"` // size of a fragment in bytes; defaults to 200
+	Fragments    int      `yaml:"fragments,omitempty" json:"fragments,omitempty"`         // max number of fragments per field; defaults to 1
+	PreTag       string   `yaml:"pre_tag,omitempty" json:"pre_tag,omitempty"`             // inserted before a match; defaults to <mark>
+	PostTag      string   `yaml:"post_tag,omitempty" json:"post_tag,omitempty"`           // inserted after a match; defaults to </mark>
+}
+
+type highlighter struct {
+	hl     *highlight.SimpleHighlighter
+	fields []string
+	num    int
+	as     map[string]*analysis.Analyzer
+	terms  map[string]map[string]bool // analyzed query terms per field
+}
+
+// stored values are analyzed with the analyzers of the index, the ones their terms were indexed with
+func newHighlighter(query string, sc *SearchConfig, ic IndexConfig) *highlighter {
+	hc := sc.Highlight
+	if hc == nil {
+		return nil
+	}
+	h := &highlighter{
+		fields: hc.Fields,
+		num:    max(hc.Fragments, 1),
+		as:     ic.Mapping.analyzers(ic.AnalyzerConfig.GetAnalyzers()),
+		terms:  map[string]map[string]bool{},
+	}
+	if len(h.fields) == 0 {
+		h.fields = sc.ReturnFields
+	}
+	pre, post := hc.PreTag, hc.PostTag
+	if pre == "" && post == "" {
+		pre, post = "<mark>", "</mark>"
+	}
+	size := hc.FragmentSize
+	if size <= 0 {
+		size = 200
+	}
+	h.hl = highlight.NewSimpleHighlighter(
+		highlight.NewSimpleFragmenterSized(size),
+		highlight.NewHTMLFragmentFormatterTags(pre, post),
+		highlight.DefaultSeparator)
+	// analyze the query the same way as the highlighted field values
+	for _, field := range h.fields {
+		h.terms[field] = map[string]bool{}
+		for _, token := range h.analyzer(field).Analyze([]byte(query)) {
+			h.terms[field][string(token.Term)] = true
+		}
+	}
+	return h
+}
+
+func (h *highlighter) analyzer(field string) *analysis.Analyzer {
+	a, ok := h.as[field]
+	if !ok {
+		a = h.as["*"]
+	}
+	if a == nil {
+		return analyzer.NewStandardAnalyzer()
+	}
+	return a
+}
+
+// fragments of the stored values of a match which contain query terms
+func (h *highlighter) highlight(match *search.DocumentMatch, values map[string][][]byte) map[string][]string {
+	// terms the index matched, like fuzzy expansions of the query; without term positions there are none
+	matched := map[string]bool{}
+	for _, tlm := range match.Locations {
+		for term := range tlm {
+			matched[term] = true
+		}
+	}
+	highlights := map[string][]string{}
+	for _, field := range h.fields {
+		a := h.analyzer(field)
+		for _, value := range values[field] {
+			if len(highlights[field]) >= h.num {
+				break
+			}
+			tlm := search.TermLocationMap{}
+			var pos int
+			for _, token := range a.Analyze(value) {
+				pos += token.PositionIncr
+				term := string(token.Term)
+				if h.terms[field][term] || matched[term] {
+					tlm.AddLocation(term, &search.Location{Pos: pos, Start: token.Start, End: token.End})
+				}
+			}
+			if len(tlm) == 0 {
+				continue
+			}
+			highlights[field] = append(highlights[field], h.hl.BestFragments(tlm, value, h.num-len(highlights[field]))...)
+		}
+	}
+	if len(highlights) == 0 {
+		return nil
+	}
+	return highlights
+}
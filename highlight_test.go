@@ -0,0 +1,69 @@
+package sled
+
+import (
+	"context"
+	"testing"
+
+	"github.com/foomo/bluge-sled/analyzer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchHighlight(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	sc := newTestSearchConfig()
+	sc.Limit = 1
+	sc.Highlight = &HighlightConfig{Fields: []string{"info", "title"}}
+	res, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	require.Len(t, res.Hits, 1)
+	assert.Equal(t, map[string][]string{
+		"info":  {"comfortable cotton <mark>shirts</mark> &amp; &lt;b&gt;tops&lt;/b&gt;"},
+		"title": {"<mark>shirt</mark>"},
+	}, res.Hits[0].Highlights)
+
+	sc.Highlight = &HighlightConfig{Fields: []string{"info"}, PreTag: "[", PostTag: "]"}
+	res, err = idx.Search(context.Background(), "cotton", sc)
+	require.NoError(t, err)
+	require.Len(t, res.Hits, 1)
+	assert.Equal(t, []string{"comfortable [cotton] shirts &amp; &lt;b&gt;tops&lt;/b&gt;"}, res.Hits[0].Highlights["info"])
+}
+
+func TestSearchHighlightFuzzy(t *testing.T) {
+	sc := newTestSearchConfig()
+	sc.Limit = 1
+	sc.Highlight = &HighlightConfig{Fields: []string{"title"}}
+	// the expansions of a fuzzy query are only known from the indexed term locations
+	idx := newTestIndex(t, 2, func(ic *IndexConfig) { ic.TermPositions = true })
+	res, err := idx.Search(context.Background(), "shrit", sc)
+	require.NoError(t, err)
+	require.Len(t, res.Hits, 1)
+	assert.Equal(t, map[string][]string{"title": {"<mark>shirt</mark>"}}, res.Hits[0].Highlights)
+
+	idx = newTestIndex(t, 2)
+	res, err = idx.Search(context.Background(), "shrit", sc)
+	require.NoError(t, err)
+	require.Len(t, res.Hits, 1)
+	assert.Nil(t, res.Hits[0].Highlights)
+}
+
+func TestSearchHighlightMapping(t *testing.T) {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	ic := NewDefaultIndexConfig("test", "id", true, *ac)
+	ic.Mapping = Mapping{
+		"code": {Type: TextFieldType, Store: true, Analyzer: analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.WhitespaceTokenizer)},
+	}
+	idx, err := NewIndex(ic)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = idx.Close() })
+	require.NoError(t, idx.BatchInsert([]map[string]any{{"id": "a", "code": "abc-123 widget"}}))
+
+	sc := newTestSearchConfig()
+	sc.SearchFields = []string{"code"}
+	sc.Highlight = &HighlightConfig{Fields: []string{"code"}}
+	res, err := idx.Search(context.Background(), "abc-123", sc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, hitIds(res.Hits))
+	// the value is analyzed with the mapped analyzer, not the one of the search config
+	assert.Equal(t, []string{"<mark>abc-123</mark> widget"}, res.Hits[0].Highlights["code"])
+}
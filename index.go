@@ -4,42 +4,119 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
+	"os"
 	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/blugelabs/bluge"
+	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"golang.org/x/sync/errgroup"
 )
 
 type Index struct {
-	ic     IndexConfig
-	shards map[int]*shard
+	ic         IndexConfig
+	shards     map[int]*shard
+	generation int          // of the shards on disk, see Reshard
+	mu         sync.RWMutex // closing waits for running operations
+	closed     bool
+
+	reshardMu sync.Mutex
 }
 
+var ErrClosed = errors.New("index is closed")
+
 func NewIndex(ic IndexConfig) (*Index, error) {
+	if err := ic.Mapping.Validate(); err != nil {
+		return nil, err
+	}
+	var generation int
 	var err error
+	if ic.ShardPath != "" {
+		if generation, err = checkManifest(ic); err != nil {
+			return nil, err
+		}
+	}
 	shards := make(map[int]*shard, ic.ShardNum)
 	for i := range ic.ShardNum {
-		shards[i], err = newShard(i, ic)
+		shards[i], err = newShard(i, ic.withGeneration(generation))
 		if err != nil {
 			return nil, err
 		}
 	}
-	return &Index{ic, shards}, nil
+	return &Index{ic: ic, shards: shards, generation: generation}, nil
 }
 
-func (i Index) BatchInsert(data []map[string]any) error {
+// read lock the index for an operation, unless it is closed
+func (i *Index) rlock() error {
+	i.mu.RLock()
+	if i.closed {
+		i.mu.RUnlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+// Close waits for running operations and closes all shards, flushing pending writes.
+// afterwards all operations fail with ErrClosed
+func (i *Index) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.closed {
+		return ErrClosed
+	}
+	i.closed = true
+	return i.closeShards()
+}
+
+// Refresh makes all written documents visible to searches right away, regardless of IndexConfig.RefreshInterval
+func (i *Index) Refresh() error {
+	if err := i.rlock(); err != nil {
+		return err
+	}
+	defer i.mu.RUnlock()
+	for _, s := range i.shards {
+		if err := s.readers.refresh(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Index) closeShards() error {
+	eg := errgroup.Group{}
+	for _, shard := range i.shards {
+		eg.Go(shard.Close)
+	}
+	return eg.Wait()
+}
+
+// BatchInsert indexes data; documents with an id which is already indexed are replaced
+func (i *Index) BatchInsert(data []map[string]any) error {
+	if err := i.rlock(); err != nil {
+		return err
+	}
+	defer i.mu.RUnlock()
 	start := time.Now()
 	defer func() {
 		slog.Debug("batch insert complete", "len", len(data), "duration", time.Since(start))
 	}()
 	// bluge does not handle document uniqueness
-	uniqueData := lo.UniqBy(data, func(datum map[string]any) string {
-		return fmt.Sprint(datum[i.ic.IdField])
-	})
+	seen := make(map[string]bool, len(data))
 	dataByShardId := make(map[int][]map[string]any, i.ic.ShardNum)
-	for _, datum := range uniqueData {
-		shardId := getShardId(i.ic.ShardNum, fmt.Sprint(datum[i.ic.IdField]))
+	for n, datum := range data {
+		id, err := getDatumId(i.ic.IdField, datum)
+		if err != nil {
+			return errors.WithMessagef(err, "failed for item at index %d", n)
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		shardId := getShardId(i.ic.ShardNum, id)
 		dataByShardId[shardId] = append(dataByShardId[shardId], datum)
 	}
 	eg := errgroup.Group{}
@@ -51,27 +128,151 @@ func (i Index) BatchInsert(data []map[string]any) error {
 	return eg.Wait()
 }
 
-func (i Index) Update(datum map[string]any) error {
-	id := fmt.Sprint(datum[i.ic.IdField])
+// InsertOnly indexes data with new ids only. ids which are already indexed or given more than once
+// are returned as conflicts and their data is not indexed, apart from the first occurrence of a new id
+func (i *Index) InsertOnly(data []map[string]any) (conflicts []string, err error) {
+	if err := i.rlock(); err != nil {
+		return nil, err
+	}
+	defer i.mu.RUnlock()
+	start := time.Now()
+	defer func() {
+		slog.Debug("insert only complete", "len", len(data), "conflicts", len(conflicts), "duration", time.Since(start))
+	}()
+	as := i.ic.AnalyzerConfig.GetAnalyzers()
+	seen := make(map[string]bool, len(data))
+	docsByShardId := make(map[int][]*bluge.Document, i.ic.ShardNum)
+	for n, datum := range data {
+		doc, _, err := newDocument(datum, i.ic, as)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed for item at index %d", n)
+		}
+		id := string(doc.ID().Term())
+		if seen[id] {
+			conflicts = append(conflicts, id)
+			continue
+		}
+		seen[id] = true
+		shardId := getShardId(i.ic.ShardNum, id)
+		docsByShardId[shardId] = append(docsByShardId[shardId], doc)
+	}
+	var mu sync.Mutex
+	eg := errgroup.Group{}
+	for shardId, docs := range docsByShardId {
+		eg.Go(func() error {
+			shardConflicts, err := i.shards[shardId].InsertOnly(docs)
+			mu.Lock()
+			defer mu.Unlock()
+			conflicts = append(conflicts, shardConflicts...)
+			return err
+		})
+	}
+	return conflicts, eg.Wait()
+}
+
+func (i *Index) Update(datum map[string]any) error {
+	if err := i.rlock(); err != nil {
+		return err
+	}
+	defer i.mu.RUnlock()
+	id, err := getDatumId(i.ic.IdField, datum)
+	if err != nil {
+		return err
+	}
 	slog.Debug("update", "id", id)
 	shardId := getShardId(i.ic.ShardNum, id)
 	return i.shards[shardId].Update(id, datum)
 }
 
-func (i Index) Upsert(data []map[string]any) error {
-	// note: not truly an upsert
-	slog.Debug("upsert", "len", len(data))
-	var nonExisting []map[string]any
-	for _, datum := range data {
-		if err := i.Update(datum); err != nil {
-			// TODO check for specific "does not exist" error message
-			nonExisting = append(nonExisting, datum)
+type Outcome string
+
+const (
+	Created Outcome = "created"
+	Updated Outcome = "updated"
+	Failed  Outcome = "failed"
+)
+
+type UpsertResult struct {
+	Id      string
+	Outcome Outcome
+	Err     error // reason of a failed outcome
+}
+
+// Upsert inserts or replaces documents by id in one batch per shard. results are in the order of data;
+// if an id is given more than once, the last datum wins; its first result is created unless the id
+// existed before, the results after it are updated. failed results of data without an id have an empty Id.
+// a shard failing to write returns an error and marks its documents as failed
+func (i *Index) Upsert(data []map[string]any) ([]UpsertResult, error) {
+	if err := i.rlock(); err != nil {
+		return nil, err
+	}
+	defer i.mu.RUnlock()
+	start := time.Now()
+	defer func() {
+		slog.Debug("upsert complete", "len", len(data), "duration", time.Since(start))
+	}()
+	results := make([]UpsertResult, len(data))
+	as := i.ic.AnalyzerConfig.GetAnalyzers()
+	// position of the document per id, so later data replaces earlier
+	positions := map[string]int{}
+	docsByShardId := make(map[int][]*bluge.Document, i.ic.ShardNum)
+	for n, datum := range data {
+		doc, _, err := newDocument(datum, i.ic, as)
+		if err != nil {
+			results[n] = UpsertResult{Outcome: Failed, Err: err}
+			if id, err := getDatumId(i.ic.IdField, datum); err == nil {
+				results[n].Id = id
+			}
+			continue
+		}
+		id := string(doc.ID().Term())
+		results[n].Id = id
+		shardId := getShardId(i.ic.ShardNum, id)
+		if p, ok := positions[id]; ok {
+			docsByShardId[shardId][p] = doc
+			continue
+		}
+		positions[id] = len(docsByShardId[shardId])
+		docsByShardId[shardId] = append(docsByShardId[shardId], doc)
+	}
+	existed := make(map[int]map[string]bool, len(docsByShardId))
+	shardErrs := make(map[int]error, len(docsByShardId))
+	var mu sync.Mutex
+	eg := errgroup.Group{}
+	for shardId, docs := range docsByShardId {
+		eg.Go(func() error {
+			e, err := i.shards[shardId].Upsert(docs)
+			mu.Lock()
+			defer mu.Unlock()
+			existed[shardId], shardErrs[shardId] = e, err
+			return err
+		})
+	}
+	err := eg.Wait()
+	seen := map[string]bool{}
+	for n, res := range results {
+		if res.Outcome == Failed {
+			continue
 		}
+		shardId := getShardId(i.ic.ShardNum, res.Id)
+		switch {
+		case shardErrs[shardId] != nil:
+			results[n].Outcome, results[n].Err = Failed, shardErrs[shardId]
+		case existed[shardId][res.Id] || seen[res.Id]:
+			results[n].Outcome = Updated
+		default:
+			results[n].Outcome = Created
+		}
+		seen[res.Id] = true
 	}
-	return i.BatchInsert(nonExisting)
+	return results, err
 }
 
-func (i Index) BatchDelete(ids []string) error {
+func (i *Index) BatchDelete(ids []string) error {
+	if err := i.rlock(); err != nil {
+		return err
+	}
+	defer i.mu.RUnlock()
 	start := time.Now()
 	defer func() {
 		slog.Debug("batch delete complete", "len", len(ids), "duration", time.Since(start))
@@ -90,31 +291,145 @@ func (i Index) BatchDelete(ids []string) error {
 	return eg.Wait()
 }
 
-// purge any saved paths
+// DeleteByQuery deletes all documents matching the query and filters on every shard, returning the number deleted.
+// the query is analyzed with IndexConfig.AnalyzerConfig; an empty query matches all documents, so either a query or filters are required
+func (i *Index) DeleteByQuery(ctx context.Context, query string, filters []Filter) (deleted int, err error) {
+	if err := i.rlock(); err != nil {
+		return 0, err
+	}
+	defer i.mu.RUnlock()
+	if strings.TrimSpace(query) == "" && len(filters) == 0 {
+		return 0, fmt.Errorf("delete by query needs a query or filters; use Purge to delete everything")
+	}
+	start := time.Now()
+	defer func() {
+		slog.Debug("delete by query complete", "query", query, "deleted", deleted, "duration", time.Since(start))
+	}()
+	sc := &SearchConfig{AnalyzerConfig: i.ic.AnalyzerConfig, Filters: filters}
+	var mu sync.Mutex
+	eg := errgroup.Group{}
+	for _, shard := range i.shards {
+		eg.Go(func() error {
+			n, err := shard.DeleteByQuery(ctx, query, sc)
+			mu.Lock()
+			defer mu.Unlock()
+			deleted += n
+			return err
+		})
+	}
+	return deleted, eg.Wait()
+}
+
+var ErrNotFound = errors.New("document not found")
+
+// Get all stored fields of a document as visible to searches; fails with ErrNotFound if there is no document with the id
+func (i *Index) Get(ctx context.Context, id string) (Hit, error) {
+	if err := i.rlock(); err != nil {
+		return Hit{}, err
+	}
+	defer i.mu.RUnlock()
+	hits, err := i.shards[getShardId(i.ic.ShardNum, id)].Get(ctx, []string{id})
+	if err != nil {
+		return Hit{}, err
+	}
+	hit, ok := hits[id]
+	if !ok {
+		return Hit{}, fmt.Errorf("%w: %q", ErrNotFound, id)
+	}
+	return hit, nil
+}
+
+// MultiGet all stored fields of documents in the order of ids; ids without a document are left out
+func (i *Index) MultiGet(ctx context.Context, ids []string) ([]Hit, error) {
+	if err := i.rlock(); err != nil {
+		return nil, err
+	}
+	defer i.mu.RUnlock()
+	idsByShardId := make(map[int][]string, i.ic.ShardNum)
+	for _, id := range ids {
+		shardId := getShardId(i.ic.ShardNum, id)
+		idsByShardId[shardId] = append(idsByShardId[shardId], id)
+	}
+	found := make(map[string]Hit, len(ids))
+	var mu sync.Mutex
+	eg := errgroup.Group{}
+	for shardId, ids := range idsByShardId {
+		eg.Go(func() error {
+			hits, err := i.shards[shardId].Get(ctx, ids)
+			mu.Lock()
+			defer mu.Unlock()
+			maps.Copy(found, hits)
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	hits := make([]Hit, 0, len(found))
+	for _, id := range ids {
+		if hit, ok := found[id]; ok {
+			hits = append(hits, hit)
+		}
+	}
+	return hits, nil
+}
+
+// Purge removes the shard directories and the manifest of the index on disk; closes the index if it is still open.
+// only paths of this index are removed, another index with a common path prefix like "shop" and "shop-2" is kept
 func (i *Index) Purge() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.closed {
+		i.closed = true
+		if err := i.closeShards(); err != nil {
+			slog.Warn("failed closing shards", "error", err)
+		}
+	}
 	for id, shard := range i.shards {
 		if err := shard.Purge(); err != nil {
 			slog.Warn("failed purging shard", "id", id, "error", err)
 		}
 	}
-	i.shards = nil
+	if i.ic.ShardPath != "" {
+		if err := os.Remove(getManifestPath(i.ic.ShardPath)); err != nil && !os.IsNotExist(err) {
+			slog.Warn("failed removing manifest", "error", err)
+		}
+	}
 	return nil
 }
 
-func (i Index) Search(ctx context.Context, query string, sc *SearchConfig) (combined SearchResult, err error) {
+func (i *Index) Search(ctx context.Context, query string, sc *SearchConfig) (combined SearchResult, err error) {
+	if err := i.rlock(); err != nil {
+		return combined, err
+	}
+	defer i.mu.RUnlock()
 	if sc == nil {
 		return combined, fmt.Errorf("you must provide a valid SearchConfig")
 	}
 	start := time.Now()
+	if sc.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sc.Timeout)
+		defer cancel()
+	}
 	resultChan := make(chan SearchResult, i.ic.ShardNum)
-	eg := errgroup.Group{}
-	for _, shard := range i.shards {
+	var mu sync.Mutex
+	shardErrors := map[int]error{}
+	// without partial results, the first failing shard cancels the others
+	eg, egCtx := errgroup.WithContext(ctx)
+	for id, shard := range i.shards {
 		eg.Go(func() error {
 			// do shard searches
-			sr, err := shard.Search(ctx, query, sc)
-			if err != nil {
+			sr, err := shard.Search(egCtx, query, sc)
+			if err != nil && !sc.AllowPartialResults {
 				return err
 			}
+			if err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				shardErrors[id] = err
+				return nil
+			}
 			resultChan <- sr
 			return nil
 		})
@@ -123,41 +438,94 @@ func (i Index) Search(ctx context.Context, query string, sc *SearchConfig) (comb
 		return combined, err
 	}
 	close(resultChan)
+	if len(shardErrors) > 0 && len(shardErrors) == len(i.shards) {
+		id := slices.Min(lo.Keys(shardErrors))
+		return combined, fmt.Errorf("all %d shards failed, shard %d: %w", len(shardErrors), id, shardErrors[id])
+	}
+	if len(shardErrors) > 0 {
+		combined.ShardErrors = shardErrors
+		slog.Warn("partial search results", "query", query, "failed shards", len(shardErrors))
+	}
+	combined.ShardsTotal = len(i.shards)
+	combined.ShardsSucceeded = len(i.shards) - len(shardErrors)
 	// combine results
+	var shardHits [][]Hit
+	var shardFacets []map[string][]FacetBucket
 	for sr := range resultChan {
 		// slog.Debug(query, "hits", len(sr.Hits))
-		combined.Hits = append(combined.Hits, sr.Hits...)
+		shardHits = append(shardHits, sr.Hits)
+		shardFacets = append(shardFacets, sr.Facets)
 		combined.HitNumber += sr.HitNumber
+		combined.MaxScore = max(combined.MaxScore, sr.MaxScore)
 	}
-	// sort combined hits by score
-	slices.SortFunc(combined.Hits, func(a Hit, b Hit) int {
-		if a.Score < b.Score {
-			return 1
-		}
-		if a.Score > b.Score {
-			return -1
+	if sc.MaxScorePercentThreshold > 0 {
+		// shards only know their own max score
+		for s, hits := range shardHits {
+			shardHits[s] = slices.DeleteFunc(hits, func(hit Hit) bool {
+				return combined.MaxScore*(sc.MaxScorePercentThreshold/100) > hit.Score
+			})
 		}
-		return 0
-	})
-	if len(combined.Hits) > 0 {
-		combined.MaxScore = combined.Hits[0].Score
 	}
+	from := sc.From
+	if sc.SearchAfter != "" {
+		// shards already skipped everything up to the cursor
+		from = 0
+	}
+	combined.Hits = mergeHits(shardHits, from, sc.Limit, compareHits(getSortOrder(sc, i.ic)))
+	if sc.Limit != 0 && len(combined.Hits) == sc.Limit {
+		combined.NextCursor = newCursor(combined.Hits[len(combined.Hits)-1], i.ic.ShardNum)
+	}
+	combined.Facets = mergeFacets(shardFacets, sc.Facets)
 	combined.Query = query
 	combined.Duration = time.Since(start)
 	slog.Debug(query, "hits", combined.HitNumber, "max-score", combined.MaxScore, "duration", combined.Duration)
 	return combined, nil
 }
 
+// k-way merge of hits sorted per shard, returning the global window of from and limit
+func mergeHits(shardHits [][]Hit, from, limit int, cmp func(a, b Hit) int) (merged []Hit) {
+	heads := make([]int, len(shardHits))
+	for n := 0; limit == 0 || n < from+limit; n++ {
+		next := -1
+		for s, hits := range shardHits {
+			if heads[s] == len(hits) {
+				continue
+			}
+			if next == -1 || cmp(hits[heads[s]], shardHits[next][heads[next]]) < 0 {
+				next = s
+			}
+		}
+		if next == -1 {
+			break
+		}
+		if n >= from {
+			merged = append(merged, shardHits[next][heads[next]])
+		}
+		heads[next]++
+	}
+	return merged
+}
+
 type Hit struct {
-	Id     string
-	Score  float64
-	Values map[string]string
+	Id         string
+	Score      float64
+	Values     map[string]string   // first value of each returned field as string; see Fields for all values
+	Fields     map[string][]any    // all values of each returned field, keeping numbers, bools and dates
+	Highlights map[string][]string // fragments per field; see SearchConfig.Highlight
+
+	sortValue [][]byte
 }
 
 type SearchResult struct {
-	HitNumber uint64
-	MaxScore  float64
-	Duration  time.Duration
-	Query     string
-	Hits      []Hit
+	HitNumber  uint64
+	MaxScore   float64
+	Duration   time.Duration
+	Query      string
+	Hits       []Hit
+	Facets     map[string][]FacetBucket // buckets per facet name; see SearchConfig.Facets
+	NextCursor string                   // pass as SearchConfig.SearchAfter to get the following page; empty on the last page
+
+	ShardsTotal     int           // number of shards searched
+	ShardsSucceeded int           // number of shards the results are combined from
+	ShardErrors     map[int]error // errors of skipped shards by shard id; see SearchConfig.AllowPartialResults
 }
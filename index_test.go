@@ -0,0 +1,322 @@
+package sled
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/foomo/bluge-sled/analyzer"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestIndex(t *testing.T, shardNum int, opts ...func(ic *IndexConfig)) *Index {
+	t.Helper()
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	ic := NewDefaultIndexConfig("test", "id", true, *ac)
+	ic.ShardNum = shardNum
+	ic.StoreFields = []string{"*"}
+	ic.AggregateFields = []string{"brand", "in_stock"}
+	ic.SortFields = []string{"brand"}
+	for _, opt := range opts {
+		opt(&ic)
+	}
+	idx, err := NewIndex(ic)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = idx.Close() })
+	var data []map[string]any
+	for n := range 20 {
+		data = append(data, map[string]any{
+			"id":       fmt.Sprintf("doc-%02d", n),
+			"title":    "shirt",
+			"info":     "comfortable cotton shirts & <b>tops</b>",
+			"tags":     []string{"summer", "sale"},
+			"brand":    []string{"acme", "globex"}[n%2],
+			"price":    float64(n),
+			"in_stock": n%3 == 0,
+			"created":  time.Date(2024, time.Month(n%4+1), 15, 0, 0, 0, 0, time.UTC),
+		})
+	}
+	require.NoError(t, idx.BatchInsert(data))
+	return idx
+}
+
+func newTestSearchConfig() *SearchConfig {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	sc := NewDefaultSearchConfig(*ac, []string{"title", "brand", "price"})
+	sc.Limit = 0
+	return &sc
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// ids of the documents of newTestIndex with a number n matching fn
+func testDocIds(fn func(n int) bool) []string {
+	var ids []string
+	for n := range 20 {
+		if fn(n) {
+			ids = append(ids, fmt.Sprintf("doc-%02d", n))
+		}
+	}
+	return ids
+}
+
+func hitIds(hits []Hit) []string {
+	return lo.Map(hits, func(hit Hit, _ int) string { return hit.Id })
+}
+
+func TestSearchPagination(t *testing.T) {
+	idx := newTestIndex(t, 4)
+	sc := newTestSearchConfig()
+	all, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	require.ElementsMatch(t, testDocIds(func(n int) bool { return true }), hitIds(all.Hits))
+
+	var paged []Hit
+	sc.Limit = 6
+	for sc.From = 0; sc.From < 24; sc.From += sc.Limit {
+		res, err := idx.Search(context.Background(), "shirt", sc)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(20), res.HitNumber)
+		assert.LessOrEqual(t, len(res.Hits), sc.Limit)
+		paged = append(paged, res.Hits...)
+	}
+	assert.Equal(t, all.Hits, paged)
+}
+
+func TestSearchFields(t *testing.T) {
+	idx := newTestIndex(t, 1)
+	sc := newTestSearchConfig()
+	sc.Limit = 1
+	sc.ReturnFields = []string{"tags", "price", "in_stock", "created"}
+	sc.Filters = []Filter{{Range: &RangeFilter{Field: "price", Gte: ptr(3.0), Lte: ptr(3.0)}}}
+	res, err := idx.Search(context.Background(), "summer", sc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"doc-03"}, hitIds(res.Hits))
+	hit := res.Hits[0]
+	assert.Equal(t, map[string][]any{
+		"tags":     {"summer", "sale"},
+		"price":    {3.0},
+		"in_stock": {true},
+		"created":  {time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)},
+	}, hit.Fields)
+	assert.Equal(t, "summer", hit.Values["tags"])
+	assert.Equal(t, "3", hit.Values["price"])
+}
+
+func TestUpsert(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	results, err := idx.Upsert([]map[string]any{
+		{"id": "doc-00", "title": "dress"},
+		{"id": "new", "title": "shirt"},
+		{"title": "shirt"},
+		{"id": "new", "title": "dress"},
+		{"id": "doc-01", "title": "dress"},
+		{"id": "doc-01", "title": "dress"},
+		{"id": "new", "title": "coat"},
+	})
+	require.NoError(t, err)
+	// the first result of a new id is created, all later ones are updated
+	assert.Equal(t, []string{"doc-00", "new", "", "new", "doc-01", "doc-01", "new"}, lo.Map(results, func(res UpsertResult, _ int) string { return res.Id }))
+	assert.Equal(t, []Outcome{Updated, Created, Failed, Updated, Updated, Updated, Updated}, lo.Map(results, func(res UpsertResult, _ int) Outcome { return res.Outcome }))
+	assert.Error(t, results[2].Err)
+
+	res, err := idx.Search(context.Background(), "shirt", newTestSearchConfig())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, testDocIds(func(n int) bool { return n > 1 }), hitIds(res.Hits))
+	res, err = idx.Search(context.Background(), "dress", newTestSearchConfig())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"doc-00", "doc-01"}, hitIds(res.Hits))
+	// the last datum of an id wins
+	res, err = idx.Search(context.Background(), "coat", newTestSearchConfig())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new"}, hitIds(res.Hits))
+}
+
+func TestBatchInsertReplaces(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	require.NoError(t, idx.BatchInsert([]map[string]any{{"id": "doc-00", "title": "dress"}}))
+	res, err := idx.Search(context.Background(), "shirt", newTestSearchConfig())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, testDocIds(func(n int) bool { return n > 0 }), hitIds(res.Hits))
+
+	conflicts, err := idx.InsertOnly([]map[string]any{
+		{"id": "doc-01", "title": "dress"},
+		{"id": "new", "title": "dress"},
+		{"id": "new", "title": "dress"},
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"doc-01", "new"}, conflicts)
+	res, err = idx.Search(context.Background(), "dress", newTestSearchConfig())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"doc-00", "new"}, hitIds(res.Hits))
+}
+
+func TestGet(t *testing.T) {
+	idx := newTestIndex(t, 3)
+	hit, err := idx.Get(context.Background(), "doc-03")
+	require.NoError(t, err)
+	assert.Equal(t, "doc-03", hit.Id)
+	assert.Equal(t, []any{3.0}, hit.Fields["price"])
+	assert.Equal(t, []any{"summer", "sale"}, hit.Fields["tags"])
+	assert.Equal(t, "globex", hit.Values["brand"])
+
+	_, err = idx.Get(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	hits, err := idx.MultiGet(context.Background(), []string{"doc-07", "missing", "doc-01", "doc-12"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"doc-07", "doc-01", "doc-12"}, hitIds(hits))
+}
+
+func TestDeleteByQuery(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	deleted, err := idx.DeleteByQuery(context.Background(), "", []Filter{{Term: &TermFilter{Field: "brand", Value: "acme"}}})
+	require.NoError(t, err)
+	assert.Equal(t, 10, deleted)
+	deleted, err = idx.DeleteByQuery(context.Background(), "shirt", []Filter{{Range: &RangeFilter{Field: "price", Lt: ptr(5.0)}}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	_, err = idx.DeleteByQuery(context.Background(), " ", nil)
+	assert.Error(t, err)
+
+	res, err := idx.Search(context.Background(), "shirt", newTestSearchConfig())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, testDocIds(func(n int) bool { return n%2 == 1 && n >= 5 }), hitIds(res.Hits))
+}
+
+func TestClose(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	require.NoError(t, idx.Close())
+	assert.ErrorIs(t, idx.Close(), ErrClosed)
+	_, err := idx.Search(context.Background(), "shirt", newTestSearchConfig())
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorIs(t, idx.BatchInsert([]map[string]any{{"id": "new"}}), ErrClosed)
+	_, err = idx.Get(context.Background(), "doc-00")
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.NoError(t, idx.Purge())
+}
+
+func TestSearchPartialResults(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	ctx := context.Background()
+	sc := newTestSearchConfig()
+	res, err := idx.Search(ctx, "shirt", sc)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.ShardsTotal)
+	assert.Equal(t, 2, res.ShardsSucceeded)
+	assert.Empty(t, res.ShardErrors)
+
+	sc.Timeout = time.Nanosecond
+	_, err = idx.Search(ctx, "shirt", sc)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	sc.AllowPartialResults = true
+	_, err = idx.Search(ctx, "shirt", sc)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// a failing shard is skipped; its searches fail like those of a closed index, while the index can still be closed
+	sc.Timeout = 0
+	idx.shards[1].readers.close()
+	res, err = idx.Search(ctx, "shirt", sc)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.ShardsTotal)
+	assert.Equal(t, 1, res.ShardsSucceeded)
+	assert.Equal(t, map[int]error{1: ErrClosed}, res.ShardErrors)
+	assert.ElementsMatch(t, testDocIds(func(n int) bool { return getShardId(2, fmt.Sprintf("doc-%02d", n)) == 0 }), hitIds(res.Hits))
+	sc.AllowPartialResults = false
+	_, err = idx.Search(ctx, "shirt", sc)
+	assert.ErrorIs(t, err, ErrClosed)
+
+	// the error of a shard which actually failed is returned
+	sc.AllowPartialResults = true
+	idx.shards[0].readers.close()
+	_, err = idx.Search(ctx, "shirt", sc)
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorContains(t, err, "all 2 shards failed")
+	assert.NotContains(t, err.Error(), "%!w")
+}
+
+func TestBatchInsertInvalidId(t *testing.T) {
+	idx := newTestIndex(t, 1)
+	for name, datum := range map[string]map[string]any{
+		"missing": {"title": "shirt"},
+		"nil":     {"id": nil, "title": "shirt"},
+		"nil ptr": {"id": (*string)(nil), "title": "shirt"},
+		"empty":   {"id": "", "title": "shirt"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorContains(t, idx.BatchInsert([]map[string]any{datum}), `id field "id"`)
+		})
+	}
+	// nothing was inserted besides the test documents
+	res, err := idx.Search(context.Background(), "shirt", newTestSearchConfig())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, testDocIds(func(n int) bool { return true }), hitIds(res.Hits))
+}
+
+func TestBatchInsertPointerIds(t *testing.T) {
+	idx := newTestIndex(t, 4)
+	ctx := context.Background()
+	var data []map[string]any
+	for n := range 8 {
+		data = append(data, map[string]any{"id": ptr(fmt.Sprintf("ptr-%d", n)), "title": "coat"})
+	}
+	require.NoError(t, idx.BatchInsert(data))
+	// inserting again replaces the documents in the shards they were routed to
+	require.NoError(t, idx.BatchInsert(data))
+	require.NoError(t, idx.Update(map[string]any{"id": ptr("ptr-0"), "title": "coat", "brand": "acme"}))
+	for n := range 8 {
+		hit, err := idx.Get(ctx, fmt.Sprintf("ptr-%d", n))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("ptr-%d", n), hit.Id)
+	}
+	res, err := idx.Search(ctx, "coat", newTestSearchConfig())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ptr-0", "ptr-1", "ptr-2", "ptr-3", "ptr-4", "ptr-5", "ptr-6", "ptr-7"}, hitIds(res.Hits))
+}
+
+func TestBatchInsertZeroIds(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	ctx := context.Background()
+	require.NoError(t, idx.BatchInsert([]map[string]any{
+		{"id": 0, "title": "coat"},
+		{"id": false, "title": "coat"},
+	}))
+	res, err := idx.Search(ctx, "coat", newTestSearchConfig())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"0", "false"}, hitIds(res.Hits))
+}
+
+func TestPurge(t *testing.T) {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	dir := t.TempDir()
+	ic := NewDefaultIndexConfig("test", "id", false, *ac)
+	ic.ShardNum = 2
+	ic.ShardPath = filepath.Join(dir, "shop")
+	shop, err := NewIndex(ic)
+	require.NoError(t, err)
+	ic.ShardPath = filepath.Join(dir, "shop-2")
+	other, err := NewIndex(ic)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = other.Close() })
+	for _, idx := range []*Index{shop, other} {
+		require.NoError(t, idx.BatchInsert([]map[string]any{{"id": "a", "title": "shirt"}}))
+	}
+
+	require.NoError(t, shop.Purge())
+	_, err = shop.Get(context.Background(), "a")
+	assert.ErrorIs(t, err, ErrClosed)
+	// the index with a common path prefix is kept
+	assert.Equal(t, []string{"shop-2-0", "shop-2-1", "shop-2-manifest.json"}, readDirNames(t, dir))
+	require.NoError(t, other.Close())
+	other, err = OpenIndex(ic.ShardPath)
+	require.NoError(t, err)
+	hit, err := other.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", hit.Id)
+}
@@ -0,0 +1,248 @@
+package sled
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"golang.org/x/sync/errgroup"
+)
+
+type Format string
+
+const (
+	JSONFormat   Format = "json"   // a json array of objects
+	NDJSONFormat Format = "ndjson" // one json object per line
+	CSVFormat    Format = "csv"    // one row per record; see LoadConfig.CSV
+)
+
+type LoadConfig struct {
+	BatchSize int                  // documents per shard batch; defaults to 1000
+	Progress  func(p LoadProgress) // called after each written batch
+	MaxErrors int                  // stop after that many failed records; 0 will never stop
+	CSV       CSVConfig            // columns and their types of csv input
+}
+
+type LoadProgress struct {
+	Read   int // records read so far
+	Loaded int // records written to the index
+	Failed int // records which could not be indexed
+}
+
+// RecordError is the reason a single record was not loaded
+type RecordError struct {
+	Record int    // position of the record in the input, starting at 0; the line number - 1 for ndjson, the row without header for csv
+	Id     string // id of the record, if known
+	Err    error
+}
+
+func (e RecordError) Error() string {
+	if e.Id == "" {
+		return fmt.Sprintf("record %d: %v", e.Record, e.Err)
+	}
+	return fmt.Sprintf("record %d (%s): %v", e.Record, e.Id, e.Err)
+}
+
+func (e RecordError) Unwrap() error {
+	return e.Err
+}
+
+type LoadReport struct {
+	LoadProgress
+	Errors   []RecordError
+	Duration time.Duration
+}
+
+var errTooManyErrors = errors.New("too many failed records")
+
+type loadRecord struct {
+	n     int
+	id    string
+	datum map[string]any
+}
+
+// Load streams records from r into the index. records are batched per shard and as many are queued, so at most
+// twice LoadConfig.BatchSize records per shard are held in memory; reading blocks while shards are writing.
+// records which cannot be indexed are reported instead of failing the load.
+// records with the same id replace each other
+func (i *Index) Load(ctx context.Context, r io.Reader, format Format, lc *LoadConfig) (report LoadReport, err error) {
+	if err := i.rlock(); err != nil {
+		return report, err
+	}
+	defer i.mu.RUnlock()
+	if lc == nil {
+		lc = &LoadConfig{}
+	}
+	batchSize := lc.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	start := time.Now()
+	defer func() {
+		report.Duration = time.Since(start)
+		slog.Debug("load complete", "read", report.Read, "loaded", report.Loaded, "failed", report.Failed, "duration", report.Duration)
+	}()
+	var mu sync.Mutex
+	// report progress and failures of the reader and all shards
+	update := func(loaded int, errs ...RecordError) error {
+		mu.Lock()
+		defer mu.Unlock()
+		report.Loaded += loaded
+		report.Failed += len(errs)
+		report.Errors = append(report.Errors, errs...)
+		if loaded > 0 && lc.Progress != nil {
+			lc.Progress(report.LoadProgress)
+		}
+		if lc.MaxErrors > 0 && report.Failed >= lc.MaxErrors {
+			return errTooManyErrors
+		}
+		return nil
+	}
+	eg, ctx := errgroup.WithContext(ctx)
+	records := make(map[int]chan loadRecord, i.ic.ShardNum)
+	for shardId, s := range i.shards {
+		c := make(chan loadRecord, batchSize)
+		records[shardId] = c
+		eg.Go(func() error {
+			return s.load(ctx, c, batchSize, update)
+		})
+	}
+	eg.Go(func() error {
+		defer func() {
+			for _, c := range records {
+				close(c)
+			}
+		}()
+		return decodeRecords(r, format, lc.CSV.withMapping(i.ic.Mapping), func(n int, datum map[string]any, err error) error {
+			mu.Lock()
+			report.Read++
+			mu.Unlock()
+			if err != nil {
+				return update(0, RecordError{Record: n, Err: err})
+			}
+			id, err := getDatumId(i.ic.IdField, datum)
+			if err != nil {
+				return update(0, RecordError{Record: n, Err: err})
+			}
+			rec := loadRecord{n: n, id: id, datum: datum}
+			select {
+			case records[getShardId(i.ic.ShardNum, rec.id)] <- rec:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	})
+	return report, eg.Wait()
+}
+
+// call fn for every record; invalid records are passed as errors, unless the input cannot be read any further
+func decodeRecords(r io.Reader, format Format, cc CSVConfig, fn func(n int, datum map[string]any, err error) error) error {
+	switch format {
+	case CSVFormat:
+		return decodeCSV(r, cc, fn)
+	case NDJSONFormat:
+		br := bufio.NewReader(r)
+		for n := 0; ; n++ {
+			line, err := br.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				var datum map[string]any
+				decodeErr := json.Unmarshal(line, &datum)
+				if fnErr := fn(n, datum, decodeErr); fnErr != nil {
+					return fnErr
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case JSONFormat:
+		dec := json.NewDecoder(r)
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if t != json.Delim('[') {
+			return fmt.Errorf("expected a json array, got %v", t)
+		}
+		for n := 0; dec.More(); n++ {
+			var datum map[string]any
+			err := dec.Decode(&datum)
+			var typeErr *json.UnmarshalTypeError
+			if err != nil && !errors.As(err, &typeErr) {
+				// syntax errors leave the decoder behind
+				return RecordError{Record: n, Err: err}
+			}
+			if err := fn(n, datum, err); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// write records in batches until the channel is closed
+func (s *shard) load(ctx context.Context, records <-chan loadRecord, batchSize int, update func(loaded int, errs ...RecordError) error) error {
+	as := s.ic.AnalyzerConfig.GetAnalyzers()
+	var docs []*bluge.Document
+	var pending int // records in docs, including replaced ones
+	positions := map[string]int{}
+	flush := func() error {
+		if len(docs) == 0 {
+			return nil
+		}
+		b := bluge.NewBatch()
+		for _, doc := range docs {
+			b.Update(doc.ID(), doc)
+		}
+		s.mu.Lock()
+		err := s.write(b, docIds(docs))
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		loaded := pending
+		docs, pending = docs[:0], 0
+		clear(positions)
+		return update(loaded)
+	}
+	for rec := range records {
+		doc, _, err := newDocument(rec.datum, s.ic, as)
+		if err != nil {
+			if err := update(0, RecordError{Record: rec.n, Id: rec.id, Err: err}); err != nil {
+				return err
+			}
+			continue
+		}
+		pending++
+		// updates of the same id within a batch would all be inserted
+		if p, ok := positions[rec.id]; ok {
+			docs[p] = doc
+			continue
+		}
+		positions[rec.id] = len(docs)
+		docs = append(docs, doc)
+		if len(docs) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
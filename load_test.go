@@ -0,0 +1,59 @@
+package sled
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/foomo/bluge-sled/analyzer"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		input  string
+	}{
+		{"ndjson", NDJSONFormat, `{"id": "a", "title": "shirt"}
+{"id": "b", "title": "shirt"}
+{"id": "c", "title": "shirt"
+{"title": "shirt"}
+
+{"id": "a", "title": "shirt", "price": 1}
+`},
+		{"json", JSONFormat, `[{"id": "a", "title": "shirt"}, {"id": "b", "title": "shirt"}, "c", {"title": "shirt"}, {"id": "a", "title": "shirt", "price": 1}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+			ic := NewDefaultIndexConfig("test", "id", true, *ac)
+			ic.ShardNum = 2
+			ic.StoreFields = []string{"*"}
+			idx, err := NewIndex(ic)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = idx.Close() })
+			var progress []LoadProgress
+			report, err := idx.Load(context.Background(), strings.NewReader(tt.input), tt.format, &LoadConfig{
+				BatchSize: 1,
+				Progress:  func(p LoadProgress) { progress = append(progress, p) },
+			})
+			require.NoError(t, err)
+			assert.Equal(t, LoadProgress{Read: 5, Loaded: 3, Failed: 2}, report.LoadProgress)
+			assert.Equal(t, []int{2, 3}, lo.Map(report.Errors, func(e RecordError, _ int) int { return e.Record }))
+			assert.NotEmpty(t, progress)
+
+			sc := newTestSearchConfig()
+			sc.ReturnFields = []string{"price"}
+			res, err := idx.Search(context.Background(), "shirt", sc)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"a", "b"}, hitIds(res.Hits))
+			// the later record of a replaces the earlier one
+			a, err := idx.Get(context.Background(), "a")
+			require.NoError(t, err)
+			assert.Equal(t, []any{1.0}, a.Fields["price"])
+		})
+	}
+}
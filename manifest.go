@@ -0,0 +1,134 @@
+package sled
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// version 2 adds the generation of the shards
+	manifestVersion = 2
+	// hash used to route documents to shards, see getShardId
+	manifestHash = "xxhash64"
+)
+
+// manifest is persisted next to the shards of an index on disk, so it can be opened with the config it was created with
+type manifest struct {
+	Version     int         `json:"version"`
+	Hash        string      `json:"hash"`
+	Generation  int         `json:"generation,omitempty"` // of the shards in use, see getGenerationPath
+	IndexConfig IndexConfig `json:"index_config"`
+}
+
+func getManifestPath(basePath string) string {
+	return basePath + "-manifest.json"
+}
+
+// OpenIndex opens an index on disk with the config of its manifest
+func OpenIndex(path string) (*Index, error) {
+	m, err := readManifest(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no index found at %q: %w", path, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.compatible(); err != nil {
+		return nil, err
+	}
+	ic := m.IndexConfig
+	// the index may have been moved
+	ic.ShardPath = path
+	return NewIndex(ic)
+}
+
+func readManifest(path string) (m manifest, err error) {
+	b, err := os.ReadFile(getManifestPath(path))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("invalid manifest of index %q: %w", path, err)
+	}
+	return m, nil
+}
+
+// the manifest is replaced atomically, which switches an index on disk to another generation of shards
+func writeManifest(ic IndexConfig, generation int) error {
+	b, err := json.MarshalIndent(manifest{Version: manifestVersion, Hash: manifestHash, Generation: generation, IndexConfig: ic}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ic.ShardPath), 0o755); err != nil {
+		return err
+	}
+	tmp := getManifestPath(ic.ShardPath) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, getManifestPath(ic.ShardPath))
+}
+
+func (m manifest) compatible() error {
+	if m.Version < 1 || m.Version > manifestVersion {
+		return fmt.Errorf("index has manifest version %d, only versions up to %d are supported", m.Version, manifestVersion)
+	}
+	if m.Hash != manifestHash {
+		return fmt.Errorf("index routes documents with hash %q, only %q is supported", m.Hash, manifestHash)
+	}
+	return nil
+}
+
+// check the config against the manifest of an existing index on disk and persist it; returns the generation of its shards.
+// fields to store, aggregate or sort by may change, as they only apply to documents indexed afterwards
+func checkManifest(ic IndexConfig) (generation int, err error) {
+	m, err := readManifest(ic.ShardPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, writeManifest(ic, 0)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := m.compatible(); err != nil {
+		return 0, err
+	}
+	prev := m.IndexConfig
+	if prev.ShardNum != ic.ShardNum {
+		return 0, fmt.Errorf("index at %q has %d shards, not %d; use OpenIndex or reshard it", ic.ShardPath, prev.ShardNum, ic.ShardNum)
+	}
+	if prev.IdField != ic.IdField {
+		return 0, fmt.Errorf("index at %q uses id field %q, not %q", ic.ShardPath, prev.IdField, ic.IdField)
+	}
+	if !jsonEqual(prev.AnalyzerConfig, ic.AnalyzerConfig) {
+		return 0, fmt.Errorf("index at %q was created with a different analyzer config; reindex to change it", ic.ShardPath)
+	}
+	if prev.TermPositions != ic.TermPositions {
+		return 0, fmt.Errorf("index at %q was created with term positions %v; reindex to change it", ic.ShardPath, prev.TermPositions)
+	}
+	if !jsonEqual(prev.Mapping, ic.Mapping) {
+		return 0, fmt.Errorf("index at %q was created with a different mapping; reindex to change it", ic.ShardPath)
+	}
+	return m.Generation, writeManifest(ic, m.Generation)
+}
+
+// compare configs the way they are persisted, so nil and empty maps are the same
+func jsonEqual(a, b any) bool {
+	ab, aErr := json.Marshal(a)
+	bb, bErr := json.Marshal(b)
+	if aErr != nil || bErr != nil {
+		return false
+	}
+	return bytes.Equal(nullAsEmpty(ab), nullAsEmpty(bb))
+}
+
+func nullAsEmpty(b []byte) []byte {
+	if string(b) == "null" {
+		return []byte("{}")
+	}
+	return b
+}
@@ -0,0 +1,46 @@
+package sled
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/foomo/bluge-sled/analyzer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenIndex(t *testing.T) {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	ic := NewDefaultIndexConfig("test", "id", false, *ac)
+	ic.ShardPath = filepath.Join(t.TempDir(), "test")
+	ic.ShardNum = 3
+	ic.StoreFields = []string{"*"}
+	ic.Mapping = Mapping{"price": {Type: NumericFieldType}}
+	idx, err := NewIndex(ic)
+	require.NoError(t, err)
+	require.NoError(t, idx.BatchInsert([]map[string]any{{"id": "a", "title": "shirt", "price": 1}}))
+	require.NoError(t, idx.Close())
+
+	for name, change := range map[string]func(ic *IndexConfig){
+		"shard num": func(ic *IndexConfig) { ic.ShardNum = 2 },
+		"id field":  func(ic *IndexConfig) { ic.IdField = "sku" },
+		"analyzer":  func(ic *IndexConfig) { ic.AnalyzerConfig = nil },
+		"mapping":   func(ic *IndexConfig) { ic.Mapping = Mapping{"price": {Type: KeywordFieldType}} },
+	} {
+		changed := ic
+		change(&changed)
+		_, err := NewIndex(changed)
+		assert.Error(t, err, name)
+	}
+
+	idx, err = OpenIndex(ic.ShardPath)
+	require.NoError(t, err)
+	defer idx.Close()
+	hit, err := idx.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, []any{1.0}, hit.Fields["price"])
+
+	_, err = OpenIndex(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
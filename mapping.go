@@ -0,0 +1,259 @@
+package sled
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/analysis"
+	blugeanalyzer "github.com/blugelabs/bluge/analysis/analyzer"
+	"github.com/foomo/bluge-sled/analyzer"
+)
+
+type FieldType string
+
+const (
+	TextFieldType     FieldType = "text"      // analyzed full text
+	KeywordFieldType  FieldType = "keyword"   // exact value, not analyzed
+	NumericFieldType  FieldType = "numeric"   // float64
+	DateFieldType     FieldType = "date"      // time.Time or RFC3339 / "2006-01-02" strings
+	BoolFieldType     FieldType = "bool"      // true or false
+	GeoPointFieldType FieldType = "geo_point" // {"lat": .., "lon": ..}, [lon, lat] or "lat,lon"
+)
+
+// Mapping declares fields by their name; nested fields are named "parent.child".
+// values of mapped fields have to fit their type. unmapped fields are typed by the value of each document,
+// so they are not checked for conflicting types; map a field to enforce one
+type Mapping map[string]FieldMapping
+
+type FieldMapping struct {
+	Type         FieldType        `yaml:"type" json:"type"`
+	Index        *bool            `yaml:"index,omitempty" json:"index,omitempty"`               // make the field searchable; defaults to true
+	Store        bool             `yaml:"store,omitempty" json:"store,omitempty"`               // store the value to return it in hits; same as listing it in IndexConfig.StoreFields
+	Sortable     bool             `yaml:"sortable,omitempty" json:"sortable,omitempty"`         // same as listing it in IndexConfig.SortFields
+	Aggregatable bool             `yaml:"aggregatable,omitempty" json:"aggregatable,omitempty"` // same as listing it in IndexConfig.AggregateFields
+	Analyzer     *analyzer.Config `yaml:"analyzer,omitempty" json:"analyzer,omitempty"`         // analyzer for text fields; defaults to IndexConfig.AnalyzerConfig
+}
+
+// Validate the names, types and options of the mapped fields
+func (m Mapping) Validate() error {
+	for name, fm := range m {
+		if name == "" || strings.HasPrefix(name, "_") {
+			return fmt.Errorf("invalid mapping field name %q", name)
+		}
+		switch fm.Type {
+		case TextFieldType, KeywordFieldType, NumericFieldType, DateFieldType, BoolFieldType:
+		case GeoPointFieldType:
+			if fm.Sortable || fm.Aggregatable {
+				return fmt.Errorf("field %q of type %q cannot be sortable or aggregatable", name, fm.Type)
+			}
+		default:
+			return fmt.Errorf("field %q has unknown type %q", name, fm.Type)
+		}
+		if fm.Analyzer != nil && fm.Type != TextFieldType {
+			return fmt.Errorf("field %q of type %q cannot have an analyzer", name, fm.Type)
+		}
+	}
+	return nil
+}
+
+// search analyzers with the ones of mapped fields, so queries are analyzed like the indexed values
+func (m Mapping) analyzers(as map[string]*analysis.Analyzer) map[string]*analysis.Analyzer {
+	if len(m) == 0 {
+		return as
+	}
+	ret := maps.Clone(as)
+	if ret == nil {
+		ret = map[string]*analysis.Analyzer{}
+	}
+	for name, fm := range m {
+		switch {
+		case fm.Type == KeywordFieldType:
+			ret[name] = blugeanalyzer.NewKeywordAnalyzer()
+		case fm.Analyzer != nil:
+			ret[name] = fm.Analyzer.GetAnalyzer()
+		}
+	}
+	return ret
+}
+
+func (ic IndexConfig) isStored(field string) bool {
+	return ic.Mapping[field].Store || slices.Contains(ic.StoreFields, field) || slices.Contains(ic.StoreFields, "*")
+}
+
+// Patch, UpdateByQuery and Reshard index documents again from their stored fields, which only
+// reproduces a document if all of its fields are stored
+func (ic IndexConfig) requireAllStored(op string) error {
+	if !slices.Contains(ic.StoreFields, "*") {
+		return fmt.Errorf("%s requires all fields to be stored; set IndexConfig.StoreFields to \"*\"", op)
+	}
+	return nil
+}
+
+func (ic IndexConfig) isAggregatable(field string) bool {
+	return ic.Mapping[field].Aggregatable || slices.Contains(ic.AggregateFields, field)
+}
+
+func (ic IndexConfig) isSortable(field string) bool {
+	return ic.Mapping[field].Sortable || slices.Contains(ic.SortFields, field)
+}
+
+// add a value according to its field mapping; fails if the value does not fit the type
+func addMappedField(doc *bluge.Document, key string, value any, fm FieldMapping, a bluge.Analyzer, ic IndexConfig, types map[string]string) error {
+	if value == nil {
+		return nil
+	}
+	if vs := reflect.ValueOf(value); vs.Kind() == reflect.Slice && fm.Type != GeoPointFieldType {
+		for i := range vs.Len() {
+			if err := addMappedField(doc, key, vs.Index(i).Interface(), fm, a, ic, types); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var field *bluge.TermField
+	switch fm.Type {
+	case TextFieldType, KeywordFieldType:
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.String {
+			return fmt.Errorf("field %q of type %q does not accept %T", key, fm.Type, value)
+		}
+		s := v.String()
+		if fm.Type == KeywordFieldType {
+			field = bluge.NewKeywordField(key, s)
+		} else {
+			if fm.Analyzer != nil {
+				a = fm.Analyzer.GetAnalyzer()
+			}
+			field = bluge.NewTextField(key, s)
+			if ic.TermPositions {
+				field.SearchTermPositions()
+			}
+			if a != nil {
+				field.WithAnalyzer(a)
+			}
+		}
+		addKeywordField(doc, key, s, ic)
+		addSortField(doc, key, ic, func(name string) *bluge.TermField {
+			return bluge.NewKeywordField(name, strings.ToLower(s))
+		})
+	case NumericFieldType:
+		f, ok := toFloat64(value)
+		if !ok {
+			return fmt.Errorf("field %q of type %q does not accept %T", key, fm.Type, value)
+		}
+		field = bluge.NewNumericField(key, f)
+		addStoredType(types, key, ic, numberType)
+		addKeywordField(doc, key, strconv.FormatFloat(f, 'f', -1, 64), ic)
+		addSortField(doc, key, ic, func(name string) *bluge.TermField {
+			return bluge.NewNumericField(name, f)
+		})
+	case DateFieldType:
+		dt, err := toTime(value)
+		if err != nil {
+			return fmt.Errorf("field %q of type %q: %w", key, fm.Type, err)
+		}
+		field = bluge.NewDateTimeField(key, dt)
+		addStoredType(types, key, ic, dateType)
+		addKeywordField(doc, key, dt.Format(time.RFC3339), ic)
+		addSortField(doc, key, ic, func(name string) *bluge.TermField {
+			return bluge.NewDateTimeField(name, dt)
+		})
+	case BoolFieldType:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("field %q of type %q does not accept %T", key, fm.Type, value)
+		}
+		field = bluge.NewKeywordField(key, strconv.FormatBool(b))
+		addStoredType(types, key, ic, boolType)
+		addKeywordField(doc, key, strconv.FormatBool(b), ic)
+		addSortField(doc, key, ic, func(name string) *bluge.TermField {
+			return bluge.NewKeywordField(name, strconv.FormatBool(b))
+		})
+	case GeoPointFieldType:
+		lon, lat, err := toLonLat(value)
+		if err != nil {
+			return fmt.Errorf("field %q of type %q: %w", key, fm.Type, err)
+		}
+		field = bluge.NewGeoPointField(key, lon, lat)
+		addStoredType(types, key, ic, geoPointType)
+	default:
+		return fmt.Errorf("field %q has unknown type %q", key, fm.Type)
+	}
+	if fm.Index != nil && !*fm.Index {
+		field.FieldOptions &^= bluge.Index
+	}
+	addTermField(doc, field, nil, ic)
+	return nil
+}
+
+func toFloat64(value any) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+func toTime(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+			if dt, err := time.Parse(layout, v); err == nil {
+				return dt, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("cannot parse date %q", v)
+	}
+	return time.Time{}, fmt.Errorf("does not accept %T", value)
+}
+
+func toLonLat(value any) (lon, lat float64, err error) {
+	switch v := value.(type) {
+	case map[string]any:
+		lat, latOk := toFloat64(v["lat"])
+		lon, lonOk := toFloat64(v["lon"])
+		if latOk && lonOk {
+			return lon, lat, nil
+		}
+	case map[string]float64:
+		if lat, ok := v["lat"]; ok {
+			if lon, ok := v["lon"]; ok {
+				return lon, lat, nil
+			}
+		}
+	case []any:
+		if len(v) == 2 {
+			lon, lonOk := toFloat64(v[0])
+			lat, latOk := toFloat64(v[1])
+			if latOk && lonOk {
+				return lon, lat, nil
+			}
+		}
+	case []float64:
+		if len(v) == 2 {
+			return v[0], v[1], nil
+		}
+	case string:
+		if latStr, lonStr, ok := strings.Cut(v, ","); ok {
+			lat, latErr := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+			lon, lonErr := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+			if latErr == nil && lonErr == nil {
+				return lon, lat, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid geo point %v", value)
+}
@@ -0,0 +1,58 @@
+package sled
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/foomo/bluge-sled/analyzer"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapping(t *testing.T) {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	ic := NewDefaultIndexConfig("test", "id", true, *ac)
+	ic.Mapping = Mapping{
+		"sku":      {Type: KeywordFieldType, Store: true},
+		"stock":    {Type: NumericFieldType, Store: true, Sortable: true},
+		"released": {Type: DateFieldType, Store: true},
+		"location": {Type: GeoPointFieldType, Store: true},
+	}
+	idx, err := NewIndex(ic)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = idx.Close() })
+	require.NoError(t, idx.BatchInsert([]map[string]any{
+		{"id": "a", "title": "shirt", "sku": "AB-1", "stock": 3, "released": "2024-02-01", "location": map[string]any{"lat": 52.5, "lon": 13.4}},
+		{"id": "b", "title": "shirt", "sku": "AB-2", "stock": uint8(1), "released": "2024-03-01T10:00:00Z"},
+	}))
+	require.Error(t, idx.BatchInsert([]map[string]any{{"id": "c", "stock": "many"}}))
+	// unmapped fields are typed per document
+	require.NoError(t, idx.BatchInsert([]map[string]any{{"id": "c", "size": "large"}, {"id": "d", "size": 42}}))
+
+	sc := newTestSearchConfig()
+	sc.ReturnFields = []string{"sku", "stock", "released", "location"}
+	sc.SortBy = []SortKey{{Field: "stock"}}
+	sc.Filters = []Filter{{Term: &TermFilter{Field: "sku", Value: "AB-1"}}}
+	res, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, hitIds(res.Hits))
+	assert.Equal(t, map[string][]any{
+		"sku":      {"AB-1"},
+		"stock":    {3.0},
+		"released": {time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}, lo.OmitByKeys(res.Hits[0].Fields, []string{"location"}))
+	// geo points are stored with reduced precision
+	location := res.Hits[0].Fields["location"][0].(map[string]float64)
+	assert.InDelta(t, 52.5, location["lat"], 1e-6)
+	assert.InDelta(t, 13.4, location["lon"], 1e-6)
+
+	sc.Filters = nil
+	res, err = idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "a"}, hitIds(res.Hits))
+
+	_, err = NewIndex(IndexConfig{Mapping: Mapping{"sku": {Type: KeywordFieldType, Analyzer: ac}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,130 @@
+package sled
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/blugelabs/bluge"
+)
+
+type PatchOption func(p *patch)
+
+type patch struct {
+	mergeArrays bool
+}
+
+// UseArrayMerge appends array values of a patch to the stored values instead of replacing them.
+// values which are already stored are not added again
+func UseArrayMerge() PatchOption {
+	return func(p *patch) {
+		p.mergeArrays = true
+	}
+}
+
+// Patch changes fields of a stored document and reindexes it. nested maps are merged,
+// fields set to nil are removed and arrays replace the stored values unless UseArrayMerge is used.
+// the index has to store all of its fields with IndexConfig.StoreFields "*"
+func (i *Index) Patch(id string, partial map[string]any, opts ...PatchOption) error {
+	if err := i.rlock(); err != nil {
+		return err
+	}
+	defer i.mu.RUnlock()
+	if err := i.ic.requireAllStored("patch"); err != nil {
+		return err
+	}
+	if _, ok := partial[i.ic.IdField]; ok {
+		if v, err := getDatumId(i.ic.IdField, partial); err != nil || v != id {
+			return fmt.Errorf("patch cannot change the id field %q", i.ic.IdField)
+		}
+	}
+	var p patch
+	for _, opt := range opts {
+		opt(&p)
+	}
+	start := time.Now()
+	defer func() {
+		slog.Debug("patch complete", "id", id, "duration", time.Since(start))
+	}()
+	return i.shards[getShardId(i.ic.ShardNum, id)].Patch(id, flattenFields("", partial, i.ic.Mapping), p)
+}
+
+func (s *shard) Patch(id string, changes map[string]any, p patch) error {
+	// changes must not interleave with other writes of the document
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hits, err := s.getLatest(context.Background(), []string{id})
+	if err != nil {
+		return err
+	}
+	hit, ok := hits[id]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrNotFound, id)
+	}
+	datum := hit.datum()
+	for field, value := range changes {
+		switch {
+		case value == nil:
+			delete(datum, field)
+		case p.mergeArrays && reflect.ValueOf(value).Kind() == reflect.Slice:
+			datum[field] = mergeValues(hit.Fields[field], value)
+		default:
+			datum[field] = value
+		}
+	}
+	doc, _, err := newDocument(datum, s.ic, s.ic.AnalyzerConfig.GetAnalyzers())
+	if err != nil {
+		return err
+	}
+	b := bluge.NewBatch()
+	b.Update(doc.ID(), doc)
+	return s.write(b, []string{id})
+}
+
+// stored fields as data to index again; fields with a single value are not arrays
+func (hit Hit) datum() map[string]any {
+	datum := make(map[string]any, len(hit.Fields))
+	for field, values := range hit.Fields {
+		if len(values) == 1 {
+			datum[field] = values[0]
+		} else {
+			datum[field] = values
+		}
+	}
+	return datum
+}
+
+// nested maps as "parent.child" fields, the way they are indexed; mapped fields like geo points are kept
+func flattenFields(prefix string, m map[string]any, mapping Mapping) map[string]any {
+	flat := make(map[string]any, len(m))
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		if _, mapped := mapping[k]; !mapped {
+			if vm, ok := v.(map[string]any); ok {
+				for fk, fv := range flattenFields(k, vm, mapping) {
+					flat[fk] = fv
+				}
+				continue
+			}
+		}
+		flat[k] = v
+	}
+	return flat
+}
+
+func mergeValues(stored []any, value any) []any {
+	merged := slices.Clone(stored)
+	vs := reflect.ValueOf(value)
+	for n := range vs.Len() {
+		v := vs.Index(n).Interface()
+		if !slices.ContainsFunc(merged, func(m any) bool { return reflect.DeepEqual(m, v) }) {
+			merged = append(merged, v)
+		}
+	}
+	return merged
+}
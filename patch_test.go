@@ -0,0 +1,80 @@
+package sled
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatch(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	require.NoError(t, idx.Patch("doc-00", map[string]any{"title": "dress", "tags": []string{"sale", "new"}, "in_stock": nil}, UseArrayMerge()))
+	require.NoError(t, idx.Patch("doc-01", map[string]any{"tags": []string{"new"}, "meta": map[string]any{"color": "red"}}))
+	assert.Error(t, idx.Patch("missing", map[string]any{"title": "dress"}))
+	assert.Error(t, idx.Patch("doc-00", map[string]any{"id": "doc-99"}))
+
+	sc := newTestSearchConfig()
+	sc.ReturnFields = []string{"title", "tags", "price", "in_stock", "meta.color", "created"}
+	sc.SortBy = []SortKey{{Field: "_id"}}
+	sc.Limit = 2
+	res, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"doc-00", "doc-01"}, hitIds(res.Hits))
+	assert.Equal(t, map[string][]any{
+		"title":   {"dress"},
+		"tags":    {"summer", "sale", "new"},
+		"price":   {0.0},
+		"created": {time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+	}, res.Hits[0].Fields)
+	assert.Equal(t, []any{"new"}, res.Hits[1].Fields["tags"])
+	assert.Equal(t, []any{"red"}, res.Hits[1].Fields["meta.color"])
+	assert.Equal(t, []any{false}, res.Hits[1].Fields["in_stock"])
+
+	// the composite field is rebuilt
+	res, err = idx.Search(context.Background(), "dress", newTestSearchConfig())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"doc-00"}, hitIds(res.Hits))
+}
+
+func TestPatchConcurrentWrites(t *testing.T) {
+	idx := newTestIndex(t, 1)
+	var patches atomic.Int64
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := 0; ; n++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			assert.NoError(t, idx.Patch("doc-00", map[string]any{"patched": n}))
+			patches.Add(1)
+		}
+	}()
+	for n := range 20 {
+		datum := map[string]any{"id": "doc-00", "title": "shirt", "version": n}
+		if n%2 == 0 {
+			_, err := idx.Upsert([]map[string]any{datum})
+			require.NoError(t, err)
+		} else {
+			require.NoError(t, idx.BatchInsert([]map[string]any{datum}))
+		}
+		// a patch which read the document before the write has completed by now
+		for p := patches.Load(); patches.Load() < p+2; {
+			runtime.Gosched()
+		}
+		require.NoError(t, idx.Refresh())
+		hit, err := idx.Get(context.Background(), "doc-00")
+		require.NoError(t, err)
+		require.Equal(t, []any{float64(n)}, hit.Fields["version"], "patch reverted a concurrent write")
+	}
+	close(stop)
+	<-done
+}
@@ -1,12 +1,41 @@
 package sled
 
 import (
+	"log/slog"
 	"strings"
 
 	"github.com/blugelabs/bluge"
 	"github.com/blugelabs/bluge/analysis"
 )
 
+// text query combined with the configured filters
+func newSearchQuery(query string, sc *SearchConfig, ic IndexConfig) (bluge.Query, error) {
+	as := ic.Mapping.analyzers(sc.AnalyzerConfig.GetAnalyzers())
+	q, err := newTextQuery(query, sc, ic, as)
+	if err != nil {
+		return nil, err
+	}
+	return newFilteredQuery(q, sc.Filters, as)
+}
+
+// query string if configured, plain text otherwise
+func newTextQuery(query string, sc *SearchConfig, ic IndexConfig, as map[string]*analysis.Analyzer) (bluge.Query, error) {
+	if qs := sc.QueryString; qs != nil {
+		if err := qs.validate(); err != nil {
+			return nil, err
+		}
+		q, err := newQueryStringQuery(query, sc, ic, as)
+		if err == nil || !qs.Lenient {
+			return q, err
+		}
+		slog.Debug("searching invalid query string as plain text", "query", query, "error", err)
+	}
+	if len(sc.SearchFields) > 0 {
+		return newMultiFieldQuery(query, sc.SearchFields, sc.QueryConfig, as), nil
+	}
+	return newAllFieldsQuery(query, sc.QueryConfig, as), nil
+}
+
 func newMultiFieldQuery(query string, fields []string, qc QueryConfig, as map[string]*analysis.Analyzer) bluge.Query {
 	if strings.TrimSpace(query) == "" {
 		return bluge.NewMatchAllQuery()
@@ -25,13 +54,17 @@ func newAllFieldsQuery(query string, qc QueryConfig, as map[string]*analysis.Ana
 	return getQuery(query, "_all", qc, as)
 }
 
-func getQuery(query, field string, qc QueryConfig, as map[string]*analysis.Analyzer) bluge.Query {
+func getAnalyzer(field string, as map[string]*analysis.Analyzer) *analysis.Analyzer {
 	a, ok := as[field]
 	if !ok {
 		a = as["*"]
 	}
+	return a
+}
+
+func getQuery(query, field string, qc QueryConfig, as map[string]*analysis.Analyzer) bluge.Query {
 	q := bluge.NewMatchQuery(query).
-		SetAnalyzer(a).
+		SetAnalyzer(getAnalyzer(field, as)).
 		SetField(field)
 	if qc.GetFuzzyness(field) != 1 {
 		q.SetFuzziness(2)
@@ -0,0 +1,584 @@
+package sled
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/analysis"
+	"github.com/samber/lo"
+)
+
+type QueryOperator string
+
+const (
+	OrQueryOperator  QueryOperator = "or"  // any term has to match
+	AndQueryOperator QueryOperator = "and" // all terms have to match
+)
+
+// QueryStringConfig parses queries like `"red shirt" brand:acme -kids price:[10 TO 50]`:
+//   - "quoted phrases"
+//   - field:value, field:"phrase" and field:(grouped values); values without a field search SearchConfig.SearchFields
+//   - +required, -excluded and NOT excluded values
+//   - AND and OR, binding in that order, and (grouping)
+//   - ranges field:[10 TO 50], field:{a TO b} excluding the bounds, * as an open bound, and field:>10, >=, < and <=
+//   - wildcards shi* and sh?rt; field:* matches documents with any value of the field
+//
+// special characters are escaped with a backslash. values are searched the way the field is mapped,
+// unmapped fields by the analyzers of SearchConfig.AnalyzerConfig
+type QueryStringConfig struct {
+	DefaultOperator QueryOperator `yaml:"default_operator,omitempty" json:"default_operator,omitempty"` // combines values without an operator; defaults to or
+	Lenient         bool          `yaml:"lenient,omitempty" json:"lenient,omitempty"`                   // search a query with bad syntax or values as plain text instead of failing
+}
+
+func (qs QueryStringConfig) validate() error {
+	switch qs.DefaultOperator {
+	case "", OrQueryOperator, AndQueryOperator:
+		return nil
+	}
+	return fmt.Errorf("unknown default operator %q", qs.DefaultOperator)
+}
+
+type queryTokenKind int
+
+const (
+	wordToken queryTokenKind = iota
+	phraseToken
+	rangeToken // [min TO max] including the brackets
+	fieldToken // name of a field followed by a colon
+	requiredToken
+	excludedToken
+	andToken
+	orToken
+	openToken
+	closeToken
+)
+
+type queryToken struct {
+	kind     queryTokenKind
+	text     string
+	pos      int  // position of the first rune in the query
+	wildcard bool // word with unescaped * or ?
+}
+
+// split a query string into tokens; fails on unterminated phrases, ranges and escapes
+func lexQueryString(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	rs := []rune(query)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: openToken, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: closeToken, text: ")", pos: i})
+			i++
+		case r == '!' || (r == '+' || r == '-') && !afterFieldToken(tokens):
+			// the sign of a field value like price:-1 is part of the value
+			kind := excludedToken
+			if r == '+' {
+				kind = requiredToken
+			}
+			tokens = append(tokens, queryToken{kind: kind, text: string(r), pos: i})
+			i++
+		case r == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(rs) && rs[j] != '"'; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j == len(rs) {
+				return nil, fmt.Errorf("invalid query at %d: unterminated phrase", i)
+			}
+			tokens = append(tokens, queryToken{kind: phraseToken, text: sb.String(), pos: i})
+			i = j + 1
+		case r == '[' || r == '{':
+			j := i + 1
+			for j < len(rs) && rs[j] != ']' && rs[j] != '}' {
+				j++
+			}
+			if j == len(rs) {
+				return nil, fmt.Errorf("invalid query at %d: unterminated range", i)
+			}
+			tokens = append(tokens, queryToken{kind: rangeToken, text: string(rs[i : j+1]), pos: i})
+			i = j + 1
+		default:
+			// a colon ends the name of a field, unless the word is the value of one
+			afterField := afterFieldToken(tokens)
+			t := queryToken{kind: wordToken, pos: i}
+			var sb strings.Builder
+			var escaped bool
+			for ; i < len(rs); i++ {
+				r := rs[i]
+				if r == '\\' {
+					if i+1 == len(rs) {
+						return nil, fmt.Errorf("invalid query at %d: escape at the end", i)
+					}
+					i++
+					sb.WriteRune(rs[i])
+					escaped = true
+					continue
+				}
+				if unicode.IsSpace(r) || r == '(' || r == ')' || r == '"' {
+					break
+				}
+				if r == ':' && !afterField && sb.Len() > 0 {
+					t.kind = fieldToken
+					i++
+					break
+				}
+				if r == '*' || r == '?' {
+					t.wildcard = true
+				}
+				sb.WriteRune(r)
+			}
+			t.text = sb.String()
+			if t.kind == wordToken && !escaped && !afterField {
+				switch t.text {
+				case "AND", "&&":
+					t.kind = andToken
+				case "OR", "||":
+					t.kind = orToken
+				case "NOT":
+					t.kind = excludedToken
+				}
+			}
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+// whether the next token is the value of a field
+func afterFieldToken(tokens []queryToken) bool {
+	return len(tokens) > 0 && tokens[len(tokens)-1].kind == fieldToken
+}
+
+type queryOccur int
+
+const (
+	shouldOccur queryOccur = iota
+	mustOccur
+	mustNotOccur
+)
+
+type queryClause struct {
+	occur queryOccur
+	q     bluge.Query
+}
+
+// query of the clause on its own, so it can be combined with OR
+func (c queryClause) query() bluge.Query {
+	if c.occur == mustNotOccur {
+		return newMustNotQuery(c.q)
+	}
+	return c.q
+}
+
+// add the clause to an AND
+func (c queryClause) require(bq *bluge.BooleanQuery) {
+	if c.occur == mustNotOccur {
+		bq.AddMustNot(c.q)
+	} else {
+		bq.AddMust(c.q)
+	}
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	sc     *SearchConfig
+	ic     IndexConfig
+	as     map[string]*analysis.Analyzer
+}
+
+// compile a query string to a query on SearchConfig.SearchFields or composite "_all"
+func newQueryStringQuery(query string, sc *SearchConfig, ic IndexConfig, as map[string]*analysis.Analyzer) (bluge.Query, error) {
+	tokens, err := lexQueryString(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return bluge.NewMatchAllQuery(), nil
+	}
+	fields := sc.SearchFields
+	if len(fields) == 0 {
+		fields = []string{"_all"}
+	}
+	p := &queryParser{tokens: tokens, sc: sc, ic: ic, as: as}
+	q, err := p.parseSeq(fields)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return q, nil
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.pos == len(p.tokens) {
+		return queryToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *queryParser) next() (queryToken, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return t, ok
+}
+
+func (p *queryParser) peekKind(kind queryTokenKind) bool {
+	t, ok := p.peek()
+	return ok && t.kind == kind
+}
+
+func (p *queryParser) accept(kind queryTokenKind) bool {
+	if p.peekKind(kind) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) errorf(t queryToken, format string, args ...any) error {
+	return fmt.Errorf("invalid query at %d: %s", t.pos, fmt.Sprintf(format, args...))
+}
+
+// clauses up to the end or a closing parenthesis, combined by the default operator
+func (p *queryParser) parseSeq(fields []string) (bluge.Query, error) {
+	var clauses []queryClause
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind == closeToken {
+			break
+		}
+		c, err := p.parseOr(fields)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, c)
+	}
+	if len(clauses) == 0 {
+		if t, ok := p.peek(); ok {
+			return nil, p.errorf(t, "unexpected %q", t.text)
+		}
+		return nil, fmt.Errorf("invalid query: unexpected end")
+	}
+	if len(clauses) == 1 && clauses[0].occur == shouldOccur {
+		return clauses[0].q, nil
+	}
+	bq := bluge.NewBooleanQuery()
+	if lo.EveryBy(clauses, func(c queryClause) bool { return c.occur == mustNotOccur }) {
+		// only excluded values match all other documents, also in a group
+		bq.AddMust(bluge.NewMatchAllQuery())
+	}
+	for _, c := range clauses {
+		switch {
+		case c.occur == mustNotOccur:
+			bq.AddMustNot(c.q)
+		case c.occur == mustOccur || p.sc.QueryString.DefaultOperator == AndQueryOperator:
+			bq.AddMust(c.q)
+		default:
+			bq.AddShould(c.q)
+		}
+	}
+	return bq, nil
+}
+
+func (p *queryParser) parseOr(fields []string) (queryClause, error) {
+	c, err := p.parseAnd(fields)
+	if err != nil || !p.peekKind(orToken) {
+		return c, err
+	}
+	bq := bluge.NewBooleanQuery().AddShould(c.query())
+	for p.accept(orToken) {
+		if c, err = p.parseAnd(fields); err != nil {
+			return c, err
+		}
+		bq.AddShould(c.query())
+	}
+	return queryClause{q: bq}, nil
+}
+
+func (p *queryParser) parseAnd(fields []string) (queryClause, error) {
+	c, err := p.parseClause(fields)
+	if err != nil || !p.peekKind(andToken) {
+		return c, err
+	}
+	bq := bluge.NewBooleanQuery()
+	c.require(bq)
+	excluded := c.occur == mustNotOccur
+	for p.accept(andToken) {
+		if c, err = p.parseClause(fields); err != nil {
+			return c, err
+		}
+		c.require(bq)
+		excluded = excluded && c.occur == mustNotOccur
+	}
+	if excluded {
+		// only excluded values match all other documents
+		bq.AddMust(bluge.NewMatchAllQuery())
+	}
+	return queryClause{q: bq}, nil
+}
+
+func (p *queryParser) parseClause(fields []string) (queryClause, error) {
+	var c queryClause
+	switch {
+	case p.accept(requiredToken):
+		c.occur = mustOccur
+	case p.accept(excludedToken):
+		c.occur = mustNotOccur
+	}
+	q, err := p.parseUnit(fields)
+	c.q = q
+	return c, err
+}
+
+func (p *queryParser) parseUnit(fields []string) (bluge.Query, error) {
+	t, ok := p.next()
+	if !ok {
+		return nil, fmt.Errorf("invalid query: unexpected end")
+	}
+	switch t.kind {
+	case openToken:
+		return p.parseGroup(t, fields)
+	case fieldToken:
+		v, ok := p.next()
+		if !ok {
+			return nil, p.errorf(t, "field %q without value", t.text)
+		}
+		switch {
+		case v.kind == openToken:
+			return p.parseGroup(v, []string{t.text})
+		case v.kind == rangeToken:
+			return p.parseRange(t.text, v)
+		case v.kind == wordToken && (strings.HasPrefix(v.text, "<") || strings.HasPrefix(v.text, ">")):
+			return p.parseComparison(t.text, v)
+		case v.kind == wordToken || v.kind == phraseToken:
+			return p.valueQuery([]string{t.text}, v)
+		}
+		return nil, p.errorf(v, "unexpected %q after field %q", v.text, t.text)
+	case rangeToken:
+		return nil, p.errorf(t, "range requires a field")
+	case wordToken, phraseToken:
+		return p.valueQuery(fields, t)
+	}
+	return nil, p.errorf(t, "unexpected %q", t.text)
+}
+
+func (p *queryParser) parseGroup(open queryToken, fields []string) (bluge.Query, error) {
+	q, err := p.parseSeq(fields)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept(closeToken) {
+		return nil, p.errorf(open, "unclosed parenthesis")
+	}
+	return q, nil
+}
+
+// [min TO max] or {min TO max}, excluding the bound next to a curly bracket
+func (p *queryParser) parseRange(field string, t queryToken) (bluge.Query, error) {
+	bounds := strings.Fields(t.text[1 : len(t.text)-1])
+	if len(bounds) != 3 || bounds[1] != "TO" {
+		return nil, p.errorf(t, "invalid range %s", t.text)
+	}
+	return p.rangeQuery(field, t, bounds[0], bounds[2], t.text[0] == '[', t.text[len(t.text)-1] == ']')
+}
+
+// >min, >=min, <max or <=max
+func (p *queryParser) parseComparison(field string, t queryToken) (bluge.Query, error) {
+	op := t.text[:len(t.text)-len(strings.TrimLeft(t.text, "<>="))]
+	value := t.text[len(op):]
+	if value == "" {
+		return nil, p.errorf(t, "comparison %q without value", t.text)
+	}
+	switch op {
+	case ">":
+		return p.rangeQuery(field, t, value, "*", false, false)
+	case ">=":
+		return p.rangeQuery(field, t, value, "*", true, false)
+	case "<":
+		return p.rangeQuery(field, t, "*", value, false, false)
+	case "<=":
+		return p.rangeQuery(field, t, "*", value, false, true)
+	}
+	return nil, p.errorf(t, "invalid comparison %q", op)
+}
+
+// value on any of the fields
+func (p *queryParser) valueQuery(fields []string, t queryToken) (bluge.Query, error) {
+	if len(fields) == 1 {
+		return p.fieldQuery(fields[0], t)
+	}
+	bq := bluge.NewBooleanQuery()
+	for _, field := range fields {
+		q, err := p.fieldQuery(field, t)
+		if err != nil {
+			return nil, err
+		}
+		bq.AddShould(q)
+	}
+	return bq, nil
+}
+
+func (p *queryParser) fieldQuery(field string, t queryToken) (bluge.Query, error) {
+	fm, mapped := p.ic.Mapping[field]
+	if t.kind == wordToken && t.wildcard {
+		if t.text == "*" {
+			return newExistsQuery(field), nil
+		}
+		pattern := t.text
+		if fm.Type != KeywordFieldType {
+			// indexed terms are lowercased by the analyzers
+			pattern = strings.ToLower(pattern)
+		}
+		return bluge.NewWildcardQuery(pattern).SetField(field), nil
+	}
+	switch fm.Type {
+	case KeywordFieldType:
+		return bluge.NewTermQuery(t.text).SetField(field), nil
+	case BoolFieldType:
+		b, err := strconv.ParseBool(t.text)
+		if err != nil {
+			return nil, p.errorf(t, "field %q expects a bool, got %q", field, t.text)
+		}
+		return bluge.NewTermQuery(strconv.FormatBool(b)).SetField(field), nil
+	case NumericFieldType:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "field %q expects a number, got %q", field, t.text)
+		}
+		return bluge.NewNumericRangeInclusiveQuery(f, f, true, true).SetField(field), nil
+	case DateFieldType:
+		dt, err := toTime(t.text)
+		if err != nil {
+			return nil, p.errorf(t, "field %q: %v", field, err)
+		}
+		return bluge.NewDateRangeInclusiveQuery(dt, dt, true, true).SetField(field), nil
+	case GeoPointFieldType:
+		return nil, p.errorf(t, "field %q of type %q cannot be searched by value", field, fm.Type)
+	}
+	var q bluge.Query
+	switch {
+	case t.kind == phraseToken && !p.ic.TermPositions:
+		// without term positions a phrase requires all of its terms, in any order
+		mq := bluge.NewMatchQuery(t.text).SetField(field).SetOperator(bluge.MatchQueryOperatorAnd)
+		if a := getAnalyzer(field, p.as); a != nil {
+			mq.SetAnalyzer(a)
+		}
+		if b := p.sc.QueryConfig.GetBoost(field); b != 0 {
+			mq.SetBoost(b)
+		}
+		q = mq
+	case t.kind == phraseToken:
+		pq := bluge.NewMatchPhraseQuery(t.text).SetField(field)
+		if a := getAnalyzer(field, p.as); a != nil {
+			pq.SetAnalyzer(a)
+		}
+		if b := p.sc.QueryConfig.GetBoost(field); b != 0 {
+			pq.SetBoost(b)
+		}
+		q = pq
+	default:
+		q = getQuery(t.text, field, p.sc.QueryConfig, p.as)
+	}
+	// numbers of unmapped fields may be indexed as numeric values
+	if f, err := strconv.ParseFloat(t.text, 64); err == nil && !mapped && field != "_all" {
+		q = bluge.NewBooleanQuery().
+			AddShould(q).
+			AddShould(bluge.NewNumericRangeInclusiveQuery(f, f, true, true).SetField(field))
+	}
+	return q, nil
+}
+
+// values between min and max; * is an open bound. unmapped fields are ranged by the type of the bounds
+func (p *queryParser) rangeQuery(field string, t queryToken, min, max string, minInclusive, maxInclusive bool) (bluge.Query, error) {
+	if min == "*" && max == "*" {
+		return newExistsQuery(field), nil
+	}
+	fm, mapped := p.ic.Mapping[field]
+	typ := fm.Type
+	if !mapped {
+		typ = inferRangeType(min, max)
+	}
+	switch typ {
+	case NumericFieldType:
+		bounds := []float64{bluge.MinNumeric, bluge.MaxNumeric}
+		for n, v := range []string{min, max} {
+			if v == "*" {
+				continue
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, p.errorf(t, "field %q expects a number, got %q", field, v)
+			}
+			bounds[n] = f
+		}
+		return bluge.NewNumericRangeInclusiveQuery(bounds[0], bounds[1], minInclusive, maxInclusive).SetField(field), nil
+	case DateFieldType:
+		// zero times are open bounds
+		bounds := make([]time.Time, 2)
+		for n, v := range []string{min, max} {
+			if v == "*" {
+				continue
+			}
+			dt, err := toTime(v)
+			if err != nil {
+				return nil, p.errorf(t, "field %q: %v", field, err)
+			}
+			bounds[n] = dt
+		}
+		return bluge.NewDateRangeInclusiveQuery(bounds[0], bounds[1], minInclusive, maxInclusive).SetField(field), nil
+	case KeywordFieldType, TextFieldType:
+		// empty terms are open bounds
+		bounds := []string{min, max}
+		for n, v := range bounds {
+			switch {
+			case v == "*":
+				bounds[n] = ""
+			case typ == TextFieldType:
+				bounds[n] = strings.ToLower(v)
+			}
+		}
+		return bluge.NewTermRangeInclusiveQuery(bounds[0], bounds[1], minInclusive, maxInclusive).SetField(field), nil
+	}
+	return nil, p.errorf(t, "field %q of type %q does not support ranges", field, typ)
+}
+
+// numeric or date if all bounds are, text otherwise
+func inferRangeType(bounds ...string) FieldType {
+	numeric, date := true, true
+	for _, v := range bounds {
+		if v == "*" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			numeric = false
+		}
+		if _, err := toTime(v); err != nil {
+			date = false
+		}
+	}
+	switch {
+	case numeric:
+		return NumericFieldType
+	case date:
+		return DateFieldType
+	}
+	return TextFieldType
+}
@@ -0,0 +1,100 @@
+package sled
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryString(t *testing.T) {
+	idx := newTestIndex(t, 2, func(ic *IndexConfig) { ic.TermPositions = true })
+	ctx := context.Background()
+	sc := newTestSearchConfig()
+	sc.QueryString = &QueryStringConfig{}
+	all := func(n int) bool { return true }
+	even := func(n int) bool { return n%2 == 0 }
+	odd := func(n int) bool { return n%2 == 1 }
+	for query, want := range map[string]func(n int) bool{
+		"brand:acme":                             even,
+		"brand:ac*":                              even,
+		"brand:*":                                all,
+		"shirt -brand:acme":                      odd,
+		"NOT brand:acme":                         odd,
+		"+shirt brand:acme":                      all,
+		"brand:(acme OR globex)":                 all,
+		"brand:acme AND price:<4":                func(n int) bool { return even(n) && n < 4 },
+		"brand:acme OR price:1":                  func(n int) bool { return even(n) || n == 1 },
+		"(brand:acme OR price:1) AND -price:<10": func(n int) bool { return even(n) && n >= 10 },
+		"brand:acme OR -price:<18":               func(n int) bool { return even(n) || n >= 18 },
+		"-brand:acme AND -price:<15":             func(n int) bool { return odd(n) && n >= 15 },
+		"price:<2 OR (-brand:acme)":              func(n int) bool { return n < 2 || odd(n) },
+		"title:(-dress)":                         all,
+		`"cotton shirts"`:                        all,
+		`"shirts cotton"`:                        func(n int) bool { return false },
+		"price:3":                                func(n int) bool { return n == 3 },
+		"price:[10 TO 14]":                       func(n int) bool { return n >= 10 && n <= 14 },
+		"price:{10 TO 14]":                       func(n int) bool { return n > 10 && n <= 14 },
+		"price:>=15":                             func(n int) bool { return n >= 15 },
+		"price:[* TO 1]":                         func(n int) bool { return n <= 1 },
+		"created:[2024-01-01 TO 2024-01-31]":     func(n int) bool { return n%4 == 0 },
+		"in_stock:true":                          func(n int) bool { return n%3 == 0 },
+	} {
+		res, err := idx.Search(ctx, query, sc)
+		require.NoError(t, err, query)
+		assert.ElementsMatch(t, testDocIds(want), hitIds(res.Hits), query)
+	}
+	sc.QueryString.DefaultOperator = AndQueryOperator
+	res, err := idx.Search(ctx, "shirt brand:acme", sc)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, testDocIds(even), hitIds(res.Hits))
+
+	for _, query := range []string{`"cotton shirts`, "brand:(acme", "price:[1 TO]", "AND", "shirt)", "price:>", "title:"} {
+		_, err := idx.Search(ctx, query, sc)
+		assert.Error(t, err, query)
+	}
+	sc.QueryString.Lenient = true
+	res, err = idx.Search(ctx, `"cotton shirts`, sc)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, testDocIds(all), hitIds(res.Hits))
+}
+
+func TestQueryStringPhraseWithoutPositions(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	sc := newTestSearchConfig()
+	sc.QueryString = &QueryStringConfig{}
+	odd := func(n int) bool { return n%2 == 1 }
+	// phrases require all of their terms, in any order, and the other clauses still apply
+	for query, want := range map[string]func(n int) bool{
+		`"cotton shirts" -brand:acme`: odd,
+		`"shirts cotton" -brand:acme`: odd,
+		`"cotton dress"`:              func(n int) bool { return false },
+	} {
+		res, err := idx.Search(context.Background(), query, sc)
+		require.NoError(t, err, query)
+		assert.ElementsMatch(t, testDocIds(want), hitIds(res.Hits), query)
+	}
+}
+
+func TestQueryStringSignedValues(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	require.NoError(t, idx.BatchInsert([]map[string]any{{"id": "negative", "title": "coat", "price": float64(-1)}}))
+	sc := newTestSearchConfig()
+	sc.QueryString = &QueryStringConfig{}
+	for query, want := range map[string][]string{
+		"price:-1":        {"negative"},
+		"price:+0":        {"doc-00"},
+		"coat -price:-1":  nil,
+		"price:[-5 TO 0]": {"negative", "doc-00"},
+	} {
+		res, err := idx.Search(context.Background(), query, sc)
+		require.NoError(t, err, query)
+		assert.ElementsMatch(t, want, hitIds(res.Hits), query)
+	}
+	// signs without a value are errors like NOT
+	for _, query := range []string{"-", "+", "NOT", "shirt -"} {
+		_, err := idx.Search(context.Background(), query, sc)
+		assert.ErrorContains(t, err, "unexpected end", query)
+	}
+}
@@ -0,0 +1,100 @@
+package sled
+
+import (
+	"sync"
+	"time"
+
+	"github.com/blugelabs/bluge"
+)
+
+// readerManager shares a reader of a shard between concurrent searches and replaces it once documents were written
+type readerManager struct {
+	w        *bluge.Writer
+	interval time.Duration // min time between refreshes; 0 refreshes on the next search after a write
+
+	mu      sync.Mutex
+	current *sharedReader
+	stale   bool // written since the current reader was opened
+	closed  bool
+}
+
+type sharedReader struct {
+	r      *bluge.Reader
+	refs   int // searches using the reader, plus one while it is current
+	opened time.Time
+}
+
+func newReaderManager(w *bluge.Writer, interval time.Duration) *readerManager {
+	return &readerManager{w: w, interval: interval}
+}
+
+// acquire the current reader, refreshed if it is stale; release it when done
+func (rm *readerManager) acquire() (*sharedReader, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.closed {
+		return nil, ErrClosed
+	}
+	if rm.current == nil || rm.stale && time.Since(rm.current.opened) >= rm.interval {
+		if err := rm.open(); err != nil {
+			return nil, err
+		}
+	}
+	rm.current.refs++
+	return rm.current, nil
+}
+
+func (rm *readerManager) release(sr *sharedReader) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.unref(sr)
+}
+
+// make written documents visible to the next search, depending on the interval
+func (rm *readerManager) written() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.stale = true
+}
+
+// open a new reader right away, so all written documents are visible
+func (rm *readerManager) refresh() error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.closed {
+		return ErrClosed
+	}
+	return rm.open()
+}
+
+// close the current reader once it is released by all searches
+func (rm *readerManager) close() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.closed = true
+	if rm.current != nil {
+		rm.unref(rm.current)
+		rm.current = nil
+	}
+}
+
+// replace the current reader, which is closed once released by all searches
+func (rm *readerManager) open() error {
+	r, err := rm.w.Reader()
+	if err != nil {
+		return err
+	}
+	if rm.current != nil {
+		rm.unref(rm.current)
+	}
+	rm.current = &sharedReader{r: r, refs: 1, opened: time.Now()}
+	rm.stale = false
+	return nil
+}
+
+func (rm *readerManager) unref(sr *sharedReader) {
+	sr.refs--
+	if sr.refs == 0 {
+		_ = sr.r.Close()
+	}
+}
@@ -0,0 +1,40 @@
+package sled
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/foomo/bluge-sled/analyzer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefresh(t *testing.T) {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	ic := NewDefaultIndexConfig("test", "id", true, *ac)
+	ic.ShardNum = 2
+	ic.RefreshInterval = time.Hour
+	idx, err := NewIndex(ic)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = idx.Close() })
+	sc := newTestSearchConfig()
+	search := func() []string {
+		res, err := idx.Search(context.Background(), "shirt", sc)
+		require.NoError(t, err)
+		return hitIds(res.Hits)
+	}
+	require.NoError(t, idx.BatchInsert([]map[string]any{{"id": "a", "title": "shirt"}}))
+	// readers are opened by the first search
+	assert.Equal(t, []string{"a"}, search())
+	require.NoError(t, idx.BatchInsert([]map[string]any{{"id": "b", "title": "shirt"}}))
+	assert.Equal(t, []string{"a"}, search())
+	_, err = idx.Get(context.Background(), "b")
+	assert.ErrorIs(t, err, ErrNotFound)
+	// writes still see the latest documents
+	results, err := idx.Upsert([]map[string]any{{"id": "b", "title": "shirt"}})
+	require.NoError(t, err)
+	assert.Equal(t, Updated, results[0].Outcome)
+	require.NoError(t, idx.Refresh())
+	assert.ElementsMatch(t, []string{"a", "b"}, search())
+}
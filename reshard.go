@@ -0,0 +1,293 @@
+package sled
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"golang.org/x/sync/errgroup"
+)
+
+// number of documents copied in a batch per shard by Reshard
+const reshardBatchSize = 1000
+
+// Reshard copies all documents into a new set of shardNum shards and swaps it in.
+// searches and writes keep using the current shards during the copy; writes made meanwhile
+// are applied to the new shards before swapping, while operations wait for the swap.
+// on disk, the new shards are a new generation next to the current ones, see getGenerationPath.
+// requires an index with IndexConfig.StoreFields "*"
+func (i *Index) Reshard(ctx context.Context, shardNum int) (err error) {
+	if shardNum < 1 {
+		return fmt.Errorf("invalid number of shards %d", shardNum)
+	}
+	// only one reshard at a time
+	i.reshardMu.Lock()
+	defer i.reshardMu.Unlock()
+	if err := i.rlock(); err != nil {
+		return err
+	}
+	if err := i.ic.requireAllStored("reshard"); err != nil {
+		i.mu.RUnlock()
+		return err
+	}
+	start := time.Now()
+	ic := i.ic
+	ic.ShardNum = shardNum
+	generation := i.generation + 1
+	if ic.ShardPath != "" {
+		// left over from a failed reshard or from a crash before the previous generation was removed
+		if err := removeGenerations(ic.ShardPath, i.generation); err != nil {
+			i.mu.RUnlock()
+			return err
+		}
+	}
+	shards := make(map[int]*shard, shardNum)
+	for id := range shardNum {
+		s, err := newShard(id, ic.withGeneration(generation))
+		if err != nil {
+			i.mu.RUnlock()
+			discardShards(shards)
+			return err
+		}
+		shards[id] = s
+	}
+	// remember writes before the copy starts, so none are missed
+	for _, s := range i.shards {
+		s.track(true)
+	}
+	err = copyShards(ctx, i.shards, shards, ic)
+	i.mu.RUnlock()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	written := map[string]bool{}
+	for _, s := range i.shards {
+		for id := range s.track(false) {
+			written[id] = true
+		}
+	}
+	if err == nil && i.closed {
+		err = ErrClosed
+	}
+	if err == nil {
+		err = syncShards(i.shards, shards, ic, written)
+	}
+	if err != nil {
+		discardShards(shards)
+		return err
+	}
+	if err := i.swapShards(shards, ic, generation); err != nil {
+		return fmt.Errorf("failed to swap shards: %w", err)
+	}
+	slog.Debug("reshard complete", "shards", shardNum, "written meanwhile", len(written), "duration", time.Since(start))
+	return nil
+}
+
+// shards of a reshard are written to the path of a new generation, so the current ones are kept until the switch.
+// generation 0 uses the base path itself
+func getGenerationPath(basePath string, generation int) string {
+	if generation == 0 {
+		return basePath
+	}
+	return fmt.Sprintf("%v.gen%d", basePath, generation)
+}
+
+// config of the shards of a generation
+func (ic IndexConfig) withGeneration(generation int) IndexConfig {
+	if ic.ShardPath != "" {
+		ic.ShardPath = getGenerationPath(ic.ShardPath, generation)
+	}
+	return ic
+}
+
+// shard paths relative to the base path of an index: "-<shard>" or ".gen<generation>-<shard>"
+var generationShardPathRegexp = regexp.MustCompile(`^(?:\.gen(\d+))?-\d+$`)
+
+// remove the shards of all generations of an index on disk except keep
+func removeGenerations(basePath string, keep int) error {
+	dir, name := filepath.Split(basePath)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		rest, ok := strings.CutPrefix(e.Name(), name)
+		if !ok {
+			continue
+		}
+		m := generationShardPathRegexp.FindStringSubmatch(rest)
+		if m == nil {
+			continue
+		}
+		var generation int
+		if m[1] != "" {
+			if generation, err = strconv.Atoi(m[1]); err != nil {
+				continue
+			}
+		}
+		if generation == keep {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close shards which are not used and remove them from disk
+func discardShards(shards map[int]*shard) {
+	for _, s := range shards {
+		_ = s.Close()
+		_ = s.Purge()
+	}
+}
+
+// copy all stored documents to the shards they are routed to in the new shard set
+func copyShards(ctx context.Context, from, to map[int]*shard, ic IndexConfig) error {
+	as := ic.AnalyzerConfig.GetAnalyzers()
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, s := range from {
+		eg.Go(func() error {
+			r, err := s.w.Reader()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			dmi, err := r.Search(ctx, bluge.NewAllMatches(bluge.NewMatchAllQuery()))
+			if err != nil {
+				return err
+			}
+			batches := make(map[int][]*bluge.Document, len(to))
+			for {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				match, err := dmi.Next()
+				if err != nil {
+					return err
+				}
+				if match == nil {
+					break
+				}
+				hit, err := decodeMatch(match)
+				if err != nil {
+					return err
+				}
+				doc, _, err := newDocument(hit.datum(), ic, as)
+				if err != nil {
+					return fmt.Errorf("failed to copy %q: %w", hit.Id, err)
+				}
+				shardId := getShardId(ic.ShardNum, hit.Id)
+				batches[shardId] = append(batches[shardId], doc)
+				if len(batches[shardId]) == reshardBatchSize {
+					if err := to[shardId].BatchInsertDocuments(batches[shardId]); err != nil {
+						return err
+					}
+					batches[shardId] = batches[shardId][:0]
+				}
+			}
+			for shardId, docs := range batches {
+				if err := to[shardId].BatchInsertDocuments(docs); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+// apply documents written during the copy to the new shard set
+func syncShards(from, to map[int]*shard, ic IndexConfig, written map[string]bool) error {
+	as := ic.AnalyzerConfig.GetAnalyzers()
+	idsByShardId := make(map[int][]string, len(from))
+	for id := range written {
+		shardId := getShardId(len(from), id)
+		idsByShardId[shardId] = append(idsByShardId[shardId], id)
+	}
+	for shardId, ids := range idsByShardId {
+		hits, err := from[shardId].getLatest(context.Background(), ids)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			target := to[getShardId(ic.ShardNum, id)]
+			hit, ok := hits[id]
+			if !ok {
+				if err := target.BatchDelete([]string{id}); err != nil {
+					return err
+				}
+				continue
+			}
+			doc, _, err := newDocument(hit.datum(), ic, as)
+			if err != nil {
+				return fmt.Errorf("failed to copy %q: %w", id, err)
+			}
+			if err := target.BatchInsertDocuments([]*bluge.Document{doc}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replace the shards of the index. on disk, the new generation is switched to by atomically writing the manifest,
+// so a crash before keeps the current shards; they are removed only afterwards
+func (i *Index) swapShards(shards map[int]*shard, ic IndexConfig, generation int) error {
+	if ic.ShardPath == "" {
+		if err := i.closeShards(); err != nil {
+			slog.Warn("failed closing replaced shards", "error", err)
+		}
+		i.shards, i.ic = shards, ic
+		return nil
+	}
+	// flush the new shards before switching to them
+	var err error
+	for _, s := range shards {
+		if closeErr := s.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		err = writeManifest(ic, generation)
+	}
+	if err != nil {
+		for _, s := range shards {
+			_ = s.Purge()
+		}
+		return err
+	}
+	if err := i.closeShards(); err != nil {
+		slog.Warn("failed closing replaced shards", "error", err)
+	}
+	prev := i.generation
+	i.ic, i.generation = ic, generation
+	i.shards = make(map[int]*shard, ic.ShardNum)
+	for id := range ic.ShardNum {
+		s, err := newShard(id, ic.withGeneration(generation))
+		if err != nil {
+			// the index on disk is switched already and can be opened again
+			i.closed = true
+			_ = i.closeShards()
+			return err
+		}
+		i.shards[id] = s
+	}
+	if err := removeGenerations(ic.ShardPath, generation); err != nil {
+		slog.Warn("failed removing replaced shards", "generation", prev, "error", err)
+	}
+	return nil
+}
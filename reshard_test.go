@@ -0,0 +1,183 @@
+package sled
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/foomo/bluge-sled/analyzer"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReshard(t *testing.T) {
+	for name, shardPath := range map[string]string{"memory": "", "disk": filepath.Join(t.TempDir(), "test")} {
+		t.Run(name, func(t *testing.T) {
+			ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+			ic := NewDefaultIndexConfig("test", "id", shardPath == "", *ac)
+			ic.ShardPath = shardPath
+			ic.StoreFields = []string{"*"}
+			idx, err := NewIndex(ic)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = idx.Close() })
+			var data []map[string]any
+			var ids []string
+			for n := range 50 {
+				ids = append(ids, fmt.Sprintf("doc-%02d", n))
+				data = append(data, map[string]any{"id": ids[n], "title": "shirt", "price": float64(n)})
+			}
+			require.NoError(t, idx.BatchInsert(data))
+			require.NoError(t, idx.Reshard(context.Background(), 4))
+
+			sc := newTestSearchConfig()
+			sc.ReturnFields = []string{"price"}
+			res, err := idx.Search(context.Background(), "shirt", sc)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, ids, hitIds(res.Hits))
+			hit, err := idx.Get(context.Background(), "doc-07")
+			require.NoError(t, err)
+			assert.Equal(t, []any{7.0}, hit.Fields["price"])
+			if shardPath == "" {
+				return
+			}
+			// the shards of the previous generation are removed
+			assert.Equal(t, []string{"test-manifest.json", "test.gen1-0", "test.gen1-1", "test.gen1-2", "test.gen1-3"}, readDirNames(t, filepath.Dir(shardPath)))
+			require.NoError(t, idx.Close())
+			idx, err = OpenIndex(shardPath)
+			require.NoError(t, err)
+			assert.Equal(t, 4, idx.ic.ShardNum)
+			res, err = idx.Search(context.Background(), "shirt", sc)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, ids, hitIds(res.Hits))
+		})
+	}
+}
+
+func TestReshardLeftovers(t *testing.T) {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	dir := t.TempDir()
+	ic := NewDefaultIndexConfig("test", "id", false, *ac)
+	ic.ShardPath = filepath.Join(dir, "test")
+	ic.ShardNum = 2
+	ic.StoreFields = []string{"*"}
+	idx, err := NewIndex(ic)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = idx.Close() })
+	require.NoError(t, idx.BatchInsert([]map[string]any{{"id": "a", "title": "shirt"}}))
+	// a crashed reshard, one which crashed after switching, and another index with a common prefix
+	for _, name := range []string{"test.gen1-0", "test.gen1-5", "test-7", "test-other-0"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0o755))
+	}
+	require.NoError(t, idx.Reshard(context.Background(), 3))
+	assert.Equal(t, []string{"test-manifest.json", "test-other-0", "test.gen1-0", "test.gen1-1", "test.gen1-2"}, readDirNames(t, dir))
+	require.NoError(t, idx.Reshard(context.Background(), 1))
+	assert.Equal(t, []string{"test-manifest.json", "test-other-0", "test.gen2-0"}, readDirNames(t, dir))
+
+	hit, err := idx.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", hit.Id)
+}
+
+func TestReshardConcurrentWrites(t *testing.T) {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	ic := NewDefaultIndexConfig("test", "id", false, *ac)
+	ic.ShardPath = filepath.Join(t.TempDir(), "test")
+	ic.ShardNum = 2
+	ic.StoreFields = []string{"*"}
+	idx, err := NewIndex(ic)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = idx.Close() })
+	var data []map[string]any
+	for n := range 300 {
+		data = append(data, map[string]any{"id": fmt.Sprintf("doc-%04d", n), "title": "shirt", "price": float64(n)})
+	}
+	require.NoError(t, idx.BatchInsert(data))
+
+	// write, replace and delete documents until the reshard is done
+	want := map[string]float64{}
+	for n := range 300 {
+		want[fmt.Sprintf("doc-%04d", n)] = float64(n)
+	}
+	done := make(chan struct{})
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for n := 0; ; n++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			id := fmt.Sprintf("new-%04d", n)
+			if !assert.NoError(t, idx.BatchInsert([]map[string]any{{"id": id, "title": "shirt", "price": -1.0}})) {
+				return
+			}
+			want[id] = -1
+			id = fmt.Sprintf("doc-%04d", n%300)
+			if n%2 == 0 {
+				if !assert.NoError(t, idx.Patch(id, map[string]any{"price": float64(-n)})) {
+					return
+				}
+				want[id] = float64(-n)
+			} else {
+				if !assert.NoError(t, idx.BatchDelete([]string{id})) {
+					return
+				}
+				delete(want, id)
+			}
+		}
+	}()
+	require.NoError(t, idx.Reshard(context.Background(), 3))
+	close(done)
+	<-written
+
+	sc := newTestSearchConfig()
+	sc.ReturnFields = []string{"price"}
+	res, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	got := map[string]float64{}
+	for _, hit := range res.Hits {
+		got[hit.Id] = hit.Fields["price"][0].(float64)
+	}
+	assert.Equal(t, want, got)
+}
+
+func readDirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	return lo.Map(entries, func(e os.DirEntry, _ int) string { return e.Name() })
+}
+
+func TestReshardSyncsWrites(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	old := idx.shards
+	for _, s := range old {
+		s.track(true)
+	}
+	// writes while copying
+	require.NoError(t, idx.BatchDelete([]string{"doc-00"}))
+	require.NoError(t, idx.Patch("doc-01", map[string]any{"title": "dress"}))
+	ic := idx.ic
+	ic.ShardNum = 3
+	shards := map[int]*shard{}
+	for id := range 3 {
+		s, err := newShard(id, ic)
+		require.NoError(t, err)
+		shards[id] = s
+	}
+	written := map[string]bool{}
+	for _, s := range old {
+		for id := range s.track(false) {
+			written[id] = true
+		}
+	}
+	assert.Equal(t, map[string]bool{"doc-00": true, "doc-01": true}, written)
+	require.NoError(t, syncShards(old, shards, ic, written))
+	hits, err := shards[getShardId(3, "doc-01")].Get(context.Background(), []string{"doc-01"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{"dress"}, hits["doc-01"].Fields["title"])
+}
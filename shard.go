@@ -2,15 +2,18 @@ package sled
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 
 	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/index"
 	"github.com/blugelabs/bluge/search"
+	"github.com/samber/lo"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -19,6 +22,12 @@ type shard struct {
 	ic IndexConfig
 	c  bluge.Config
 	w  *bluge.Writer
+	mu sync.Mutex // serializes writes, so reading and writing a document in Upsert or Patch does not interleave with others
+
+	readers *readerManager // reader shared by searches
+
+	trackMu sync.Mutex
+	written map[string]bool // ids written while tracking, see Index.Reshard
 }
 
 func newShard(id int, ic IndexConfig) (*shard, error) {
@@ -34,7 +43,7 @@ func newShard(id int, ic IndexConfig) (*shard, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &shard{id: id, ic: ic, c: c, w: w}, nil
+	return &shard{id: id, ic: ic, c: c, w: w, readers: newReaderManager(w, ic.RefreshInterval)}, nil
 }
 
 func getShardPath(basePath string, id int) string {
@@ -42,24 +51,231 @@ func getShardPath(basePath string, id int) string {
 }
 
 func (s *shard) Close() error {
+	s.readers.close()
 	return s.w.Close()
 }
 
+// write a batch of documents with the given ids; s.mu must be held
+func (s *shard) write(b *index.Batch, ids []string) error {
+	// tracking must not stop before the batch is written, so a reshard syncs it
+	s.trackMu.Lock()
+	defer s.trackMu.Unlock()
+	if err := s.w.Batch(b); err != nil {
+		return err
+	}
+	if s.written != nil {
+		for _, id := range ids {
+			s.written[id] = true
+		}
+	}
+	s.readers.written()
+	return nil
+}
+
+// start remembering written ids; returns the ids written since tracking started and stops it
+func (s *shard) track(start bool) (written map[string]bool) {
+	s.trackMu.Lock()
+	defer s.trackMu.Unlock()
+	written = s.written
+	s.written = nil
+	if start {
+		s.written = map[string]bool{}
+	}
+	return written
+}
+
+func docIds(docs []*bluge.Document) []string {
+	ids := make([]string, len(docs))
+	for n, doc := range docs {
+		ids[n] = string(doc.ID().Term())
+	}
+	return ids
+}
+
 func (s *shard) BatchInsert(data []map[string]any) error {
-	batch, fs, err := newBatchInsert(s.id, data, s.ic.IdField, s.ic.StoreFields, s.ic.AnalyzerConfig.GetAnalyzers())
+	batch, fs, err := newBatchInsert(s.id, data, s.ic, s.ic.AnalyzerConfig.GetAnalyzers())
 	if err != nil {
 		return err
 	}
 	slog.Debug("data", "fields", strings.Join(fs, ","))
-	return s.w.Batch(batch)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(batch, lo.Map(data, func(datum map[string]any, _ int) string {
+		// valid, as the batch could be built
+		id, _ := getDatumId(s.ic.IdField, datum)
+		return id
+	}))
+}
+
+func (s *shard) BatchInsertDocuments(docs []*bluge.Document) error {
+	b := bluge.NewBatch()
+	for _, doc := range docs {
+		b.Update(doc.ID(), doc)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(b, docIds(docs))
+}
+
+// insert documents with new ids only, returning the ids already in the shard
+func (s *shard) InsertOnly(docs []*bluge.Document) (conflicts []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := docIds(docs)
+	existed, err := s.existing(ids)
+	if err != nil {
+		return nil, err
+	}
+	b := bluge.NewBatch()
+	for n, doc := range docs {
+		if existed[ids[n]] {
+			conflicts = append(conflicts, ids[n])
+			continue
+		}
+		// update anyway, so a concurrent insert of the same id is replaced
+		b.Update(doc.ID(), doc)
+	}
+	return conflicts, s.write(b, ids)
 }
 
 func (s *shard) Update(id string, datum map[string]any) error {
-	doc, _, err := newDocument(datum, s.ic.IdField, s.ic.StoreFields, s.ic.AnalyzerConfig.GetAnalyzers())
+	doc, _, err := newDocument(datum, s.ic, s.ic.AnalyzerConfig.GetAnalyzers())
 	if err != nil {
 		return err
 	}
-	return s.w.Update(doc.ID(), doc)
+	b := bluge.NewBatch()
+	b.Update(doc.ID(), doc)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(b, []string{id})
+}
+
+// insert or replace documents by id, reporting whether they existed before
+func (s *shard) Upsert(docs []*bluge.Document) (existed map[string]bool, err error) {
+	// existence and writes must not interleave with other writes
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := docIds(docs)
+	existed, err = s.existing(ids)
+	if err != nil {
+		return nil, err
+	}
+	b := bluge.NewBatch()
+	for _, doc := range docs {
+		b.Update(doc.ID(), doc)
+	}
+	return existed, s.write(b, ids)
+}
+
+// ids which are in the index
+func (s *shard) existing(ids []string) (map[string]bool, error) {
+	found := make(map[string]bool, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+	r, err := s.w.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	dmi, err := r.Search(context.Background(), bluge.NewTopNSearch(len(ids), newIdsQuery(ids)))
+	if err != nil {
+		return nil, err
+	}
+	match, err := dmi.Next()
+	for err == nil && match != nil {
+		err = match.VisitStoredFields(func(field string, value []byte) bool {
+			if field == "_id" {
+				found[string(value)] = true
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		match, err = dmi.Next()
+	}
+	return found, err
+}
+
+// all stored fields of the documents with the given ids which exist, as visible to searches
+func (s *shard) Get(ctx context.Context, ids []string) (map[string]Hit, error) {
+	if len(ids) == 0 {
+		return map[string]Hit{}, nil
+	}
+	sr, err := s.readers.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer s.readers.release(sr)
+	return getHits(ctx, sr.r, ids)
+}
+
+// like Get, but including documents written since the last refresh
+func (s *shard) getLatest(ctx context.Context, ids []string) (map[string]Hit, error) {
+	if len(ids) == 0 {
+		return map[string]Hit{}, nil
+	}
+	r, err := s.w.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return getHits(ctx, r, ids)
+}
+
+func getHits(ctx context.Context, r *bluge.Reader, ids []string) (map[string]Hit, error) {
+	hits := make(map[string]Hit, len(ids))
+	dmi, err := r.Search(ctx, bluge.NewTopNSearch(len(ids), newIdsQuery(ids)))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		match, err := dmi.Next()
+		if err != nil {
+			return nil, err
+		}
+		if match == nil {
+			break
+		}
+		hit, err := decodeMatch(match)
+		if err != nil {
+			return nil, err
+		}
+		hits[hit.Id] = hit
+	}
+	return hits, nil
+}
+
+// hit with all stored fields of a match
+func decodeMatch(match *search.DocumentMatch) (hit Hit, err error) {
+	var types map[string]string
+	stored := map[string][][]byte{}
+	if err := match.VisitStoredFields(func(field string, value []byte) bool {
+		switch field {
+		case "_id":
+			hit.Id = string(value)
+		case typesField:
+			// without types all values are returned as strings
+			_ = json.Unmarshal(value, &types)
+		default:
+			stored[field] = append(stored[field], slices.Clone(value))
+		}
+		return true
+	}); err != nil {
+		return hit, err
+	}
+	hit.Values, hit.Fields = decodeStoredFields(stored, types)
+	return hit, nil
+}
+
+func newIdsQuery(ids []string) bluge.Query {
+	q := bluge.NewBooleanQuery()
+	for _, id := range ids {
+		q.AddShould(bluge.NewTermQuery(id).SetField("_id"))
+	}
+	return q
 }
 
 func (s *shard) BatchDelete(ids []string) error {
@@ -67,52 +283,126 @@ func (s *shard) BatchDelete(ids []string) error {
 	for _, id := range ids {
 		b.Delete(bluge.Identifier(id))
 	}
-	return s.w.Batch(b)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(b, ids)
 }
 
-func (s *shard) Purge() error {
-	if err := s.Close(); err != nil {
-		return err
+// number of documents deleted in a batch by DeleteByQuery
+const deleteBatchSize = 1000
+
+// delete matching documents in batches while iterating over the matches
+func (s *shard) DeleteByQuery(ctx context.Context, query string, sc *SearchConfig) (deleted int, err error) {
+	r, err := s.w.Reader()
+	if err != nil {
+		return 0, err
 	}
-	if s.ic.ShardPath != "" {
-		paths, err := filepath.Glob(s.ic.ShardPath + "*/*")
-		if err != nil {
+	defer r.Close()
+	q, err := newSearchQuery(query, sc, s.ic)
+	if err != nil {
+		return 0, err
+	}
+	// the reader is a snapshot, so deleting does not affect the matches
+	dmi, err := r.Search(ctx, bluge.NewAllMatches(q))
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]string, 0, deleteBatchSize)
+	flush := func() error {
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := s.BatchDelete(ids); err != nil {
 			return err
 		}
-		for _, path := range paths {
-			if err := os.RemoveAll(path); err != nil {
-				return err
+		deleted += len(ids)
+		ids = ids[:0]
+		return nil
+	}
+	for {
+		match, err := dmi.Next()
+		if err != nil {
+			return deleted, err
+		}
+		if match == nil {
+			break
+		}
+		if err := match.VisitStoredFields(func(field string, value []byte) bool {
+			if field == "_id" {
+				ids = append(ids, string(value))
+				return false
+			}
+			return true
+		}); err != nil {
+			return deleted, err
+		}
+		if len(ids) == deleteBatchSize {
+			if err := ctx.Err(); err != nil {
+				return deleted, err
+			}
+			if err := flush(); err != nil {
+				return deleted, err
 			}
 		}
 	}
+	return deleted, flush()
+}
+
+// remove the saved paths of a closed shard
+func (s *shard) Purge() error {
+	if s.ic.ShardPath != "" {
+		// only the own directory; matching all paths with the prefix would purge the contents of other indexes too
+		return os.RemoveAll(getShardPath(s.ic.ShardPath, s.id))
+	}
 	return nil
 }
 
 func (s *shard) Search(ctx context.Context, query string, sc *SearchConfig) (SearchResult, error) {
 	var sr SearchResult
-	// TODO consider using sync.Pool for these
-	r, err := s.w.Reader()
+	shared, err := s.readers.acquire()
 	if err != nil {
 		return sr, err
 	}
-	defer r.Close()
+	defer s.readers.release(shared)
+	r := shared.r
 
-	var q bluge.Query
-	if len(sc.SearchFields) > 0 {
-		q = newMultiFieldQuery(query, sc.SearchFields, sc.QueryConfig, sc.AnalyzerConfig.GetAnalyzers())
-	} else {
-		q = newAllFieldsQuery(query, sc.QueryConfig, sc.AnalyzerConfig.GetAnalyzers())
+	q, err := newSearchQuery(query, sc, s.ic)
+	if err != nil {
+		return sr, err
 	}
-	var req bluge.SearchRequest
-	req = bluge.NewAllMatches(q).WithStandardAggregations()
-	if sc.Limit != 0 {
-		req = bluge.NewTopNSearch(sc.Limit, q).SetFrom(sc.From).WithStandardAggregations()
+	// a limit of 0 returns all matches; still sorted, so shard results can be merged
+	n := sc.From + sc.Limit
+	if sc.SearchAfter != "" {
+		n = sc.Limit
+	}
+	if sc.Limit == 0 {
+		count, err := r.Count()
+		if err != nil {
+			return sr, err
+		}
+		n = max(int(count), 1)
+	}
+	order := getSortOrder(sc, s.ic)
+	req := bluge.NewTopNSearch(n, q).SortByCustom(order).WithStandardAggregations()
+	if sc.SearchAfter != "" {
+		c, err := decodeCursor(sc.SearchAfter, s.ic.ShardNum, len(order))
+		if err != nil {
+			return sr, err
+		}
+		req.After(c.SortValue)
+	}
+	h := newHighlighter(query, sc, s.ic)
+	if h != nil && s.ic.TermPositions {
+		req.IncludeLocations()
+	}
+	if err := addFacets(req, sc.Facets, s.ic.ShardNum); err != nil {
+		return sr, err
 	}
 	dmi, err := r.Search(ctx, req)
 	if err != nil {
 		return sr, err
 	}
-	hits, err := processMatches(dmi, sc)
+	hits, err := processMatches(ctx, dmi, sc, h)
 	if err != nil {
 		return sr, err
 	}
@@ -121,12 +411,17 @@ func (s *shard) Search(ctx context.Context, query string, sc *SearchConfig) (Sea
 	sr.HitNumber = uint64(dmi.Aggregations().Metric("count"))
 	sr.MaxScore = dmi.Aggregations().Metric("max_score")
 	sr.Duration = dmi.Aggregations().Duration()
+	sr.Facets = getFacets(dmi.Aggregations(), sc.Facets)
 	return sr, err
 }
 
-func processMatches(dmi search.DocumentMatchIterator, sc *SearchConfig) (hits []Hit, err error) {
+func processMatches(ctx context.Context, dmi search.DocumentMatchIterator, sc *SearchConfig, h *highlighter) (hits []Hit, err error) {
 	maxScore := dmi.Aggregations().Metric("max_score")
 	for {
+		// stop loading stored fields once the search is canceled or timed out
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		match, err := dmi.Next()
 		if err != nil {
 			return nil, err
@@ -134,28 +429,45 @@ func processMatches(dmi search.DocumentMatchIterator, sc *SearchConfig) (hits []
 		if match == nil {
 			break
 		}
+		// matches are not necessarily sorted by score, so check every one of them
 		if sc.ScoreThreshold > 0 && sc.ScoreThreshold > match.Score {
 			// exclude results lower than configured threshold
-			break
+			continue
 		}
 		if sc.MaxScorePercentThreshold > 0 && maxScore*(sc.MaxScorePercentThreshold/100) > match.Score {
 			// exclude results lower than configured percent threshold
-			break
+			continue
 		}
 		var hit Hit
-		hit.Values = make(map[string]string, 1)
+		hit.sortValue = slices.Clone(match.SortValue)
+		var types map[string]string
+		var highlightValues map[string][][]byte
+		stored := make(map[string][][]byte, len(sc.ReturnFields))
 		if err := match.VisitStoredFields(func(field string, value []byte) bool {
 			switch true {
 			case field == "_id":
 				hit.Id = string(value)
 				hit.Score = match.Score
+			case field == typesField:
+				// without types all values are returned as strings
+				_ = json.Unmarshal(value, &types)
 			case sc.ReturnFields != nil && slices.Contains(sc.ReturnFields, field):
-				hit.Values[field] = string(value)
+				stored[field] = append(stored[field], slices.Clone(value))
+			}
+			if h != nil && slices.Contains(h.fields, field) {
+				if highlightValues == nil {
+					highlightValues = map[string][][]byte{}
+				}
+				highlightValues[field] = append(highlightValues[field], slices.Clone(value))
 			}
 			return true
 		}); err != nil {
 			return nil, err
 		}
+		hit.Values, hit.Fields = decodeStoredFields(stored, types)
+		if h != nil {
+			hit.Highlights = h.highlight(match, highlightValues)
+		}
 		hits = append(hits, hit)
 	}
 	return hits, nil
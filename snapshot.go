@@ -0,0 +1,235 @@
+package sled
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge"
+)
+
+// name of the manifest in a snapshot archive; the first entry of the archive
+const snapshotManifest = "manifest.json"
+
+// Snapshot writes a tar archive of all shards and the index config to w, while the index keeps serving.
+// every shard is archived as of the moment the snapshot starts; restore it with RestoreIndex
+func (i *Index) Snapshot(ctx context.Context, w io.Writer) error {
+	if err := i.rlock(); err != nil {
+		return err
+	}
+	defer i.mu.RUnlock()
+	start := time.Now()
+	// take readers of all shards first, so they are as close in time as possible
+	readers := make(map[int]*bluge.Reader, len(i.shards))
+	defer func() {
+		for _, r := range readers {
+			_ = r.Close()
+		}
+	}()
+	for id, s := range i.shards {
+		r, err := s.w.Reader()
+		if err != nil {
+			return err
+		}
+		readers[id] = r
+	}
+	tmp, err := os.MkdirTemp("", "sled-snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	cancel := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() { close(cancel) })
+	defer stop()
+	for id, r := range readers {
+		if err := os.Mkdir(getSnapshotShardPath(tmp, id), 0o755); err != nil {
+			return err
+		}
+		if err := r.Backup(getSnapshotShardPath(tmp, id), cancel); err != nil {
+			return fmt.Errorf("failed to snapshot shard %d: %w", id, err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	ic := i.ic
+	ic.ShardPath = ""
+	b, err := json.MarshalIndent(manifest{Version: manifestVersion, Hash: manifestHash, IndexConfig: ic}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: snapshotManifest, Mode: 0o644, Size: int64(len(b)), ModTime: start}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return err
+	}
+	if err := filepath.WalkDir(tmp, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return addTarFile(tw, tmp, p)
+	}); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	slog.Debug("snapshot complete", "shards", len(readers), "duration", time.Since(start))
+	return nil
+}
+
+func getSnapshotShardPath(basePath string, id int) string {
+	return filepath.Join(basePath, fmt.Sprintf("shard-%d", id))
+}
+
+func addTarFile(tw *tar.Writer, basePath, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	h, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(basePath, p)
+	if err != nil {
+		return err
+	}
+	h.Name = filepath.ToSlash(rel)
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// RestoreIndex recreates an index from a Snapshot archive at path and opens it.
+// fails if there is an index at path already
+func RestoreIndex(r io.Reader, path string) (*Index, error) {
+	if _, err := os.Stat(getManifestPath(path)); err == nil {
+		return nil, fmt.Errorf("index at %q exists already", path)
+	}
+	tr := tar.NewReader(r)
+	h, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	if h.Name != snapshotManifest {
+		return nil, fmt.Errorf("invalid snapshot: expected %s first, got %q", snapshotManifest, h.Name)
+	}
+	var m manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid snapshot manifest: %w", err)
+	}
+	if err := m.compatible(); err != nil {
+		return nil, err
+	}
+	ic := m.IndexConfig
+	if ic.ShardNum < 1 {
+		return nil, fmt.Errorf("invalid snapshot: %d shards", ic.ShardNum)
+	}
+	if err := ic.Mapping.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	ic.ShardPath = path
+	// extract next to path, so a failed restore leaves nothing behind and the shards can be renamed into place
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(path), filepath.Base(path)+".restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	restored := map[int]bool{}
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot: %w", err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		id, name, err := parseSnapshotName(h.Name, ic.ShardNum)
+		if err != nil {
+			return nil, err
+		}
+		if err := extractTarFile(tr, filepath.Join(getSnapshotShardPath(tmp, id), name), h.FileInfo().Mode()); err != nil {
+			return nil, err
+		}
+		restored[id] = true
+	}
+	for id := range ic.ShardNum {
+		if !restored[id] {
+			return nil, fmt.Errorf("invalid snapshot: shard %d is missing", id)
+		}
+	}
+	// the manifest is written last, so shards without it are no index
+	for id := range ic.ShardNum {
+		if err := os.Rename(getSnapshotShardPath(tmp, id), getShardPath(path, id)); err != nil {
+			removeShardPaths(path, id)
+			return nil, err
+		}
+	}
+	if err := writeManifest(ic, 0); err != nil {
+		removeShardPaths(path, ic.ShardNum)
+		return nil, err
+	}
+	return OpenIndex(path)
+}
+
+// remove the paths of the first n shards of an index
+func removeShardPaths(basePath string, n int) {
+	for id := range n {
+		_ = os.RemoveAll(getShardPath(basePath, id))
+	}
+}
+
+// shard and file of an archived shard file named "shard-N/file"
+func parseSnapshotName(name string, shardNum int) (id int, file string, err error) {
+	dir, file, ok := strings.Cut(name, "/")
+	if ok && strings.HasPrefix(dir, "shard-") && file != "" && filepath.IsLocal(file) {
+		if id, err := strconv.Atoi(strings.TrimPrefix(dir, "shard-")); err == nil && id >= 0 && id < shardNum {
+			return id, filepath.FromSlash(file), nil
+		}
+	}
+	return 0, "", fmt.Errorf("invalid snapshot: unexpected file %q", name)
+}
+
+func extractTarFile(r io.Reader, p string, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
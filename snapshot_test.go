@@ -0,0 +1,67 @@
+package sled
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	idx := newTestIndex(t, 3)
+	var buf bytes.Buffer
+	require.NoError(t, idx.Snapshot(context.Background(), &buf))
+	// changes after the snapshot are not restored
+	require.NoError(t, idx.BatchDelete([]string{"doc-00"}))
+	snapshot := buf.Bytes()
+
+	path := filepath.Join(t.TempDir(), "restored")
+	restored, err := RestoreIndex(bytes.NewReader(snapshot), path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = restored.Close() })
+	res, err := restored.Search(context.Background(), "shirt", newTestSearchConfig())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, testDocIds(func(n int) bool { return true }), hitIds(res.Hits))
+	hit, err := restored.Get(context.Background(), "doc-05")
+	require.NoError(t, err)
+	assert.Equal(t, []any{5.0}, hit.Fields["price"])
+	require.NoError(t, restored.Close())
+
+	_, err = RestoreIndex(bytes.NewReader(snapshot), path)
+	assert.Error(t, err, "existing index")
+	_, err = RestoreIndex(strings.NewReader("no archive"), filepath.Join(t.TempDir(), "invalid"))
+	assert.Error(t, err)
+}
+
+func TestRestoreIndexFailure(t *testing.T) {
+	idx := newTestIndex(t, 3)
+	var buf bytes.Buffer
+	require.NoError(t, idx.Snapshot(context.Background(), &buf))
+	snapshot := buf.Bytes()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "restored")
+	_, err := RestoreIndex(bytes.NewReader(snapshot[:len(snapshot)/2]), path)
+	require.Error(t, err)
+	// nothing is left of a failed restore
+	assert.Empty(t, readDirNames(t, dir))
+
+	// a shard of the index in the way
+	require.NoError(t, os.MkdirAll(filepath.Join(getShardPath(path, 2), "other"), 0o755))
+	_, err = RestoreIndex(bytes.NewReader(snapshot), path)
+	require.Error(t, err)
+	assert.Equal(t, []string{"restored-2"}, readDirNames(t, dir))
+
+	require.NoError(t, os.RemoveAll(getShardPath(path, 2)))
+	restored, err := RestoreIndex(bytes.NewReader(snapshot), path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = restored.Close() })
+	hit, err := restored.Get(context.Background(), "doc-05")
+	require.NoError(t, err)
+	assert.Equal(t, "doc-05", hit.Id)
+}
@@ -0,0 +1,53 @@
+package sled
+
+import (
+	"github.com/blugelabs/bluge/search"
+)
+
+// SortKey orders search results by a field; use "_score" for relevance
+type SortKey struct {
+	Field        string `yaml:"field" json:"field"`                                     // field to sort by; see IndexConfig.SortFields for text fields
+	Desc         bool   `yaml:"desc,omitempty" json:"desc,omitempty"`                   // sort descending
+	MissingFirst bool   `yaml:"missing_first,omitempty" json:"missing_first,omitempty"` // documents without the field come first; otherwise last
+}
+
+// sortable copy of a field
+func sortField(field string) string {
+	return field + sortSuffix
+}
+
+// configured sort keys followed by the id, so the order is the same on every shard.
+// defaults to score descending
+func getSortOrder(sc *SearchConfig, ic IndexConfig) search.SortOrder {
+	keys := sc.SortBy
+	if len(keys) == 0 {
+		keys = []SortKey{{Field: "_score", Desc: true}}
+	}
+	order := make(search.SortOrder, 0, len(keys)+1)
+	var byId bool
+	for _, key := range keys {
+		var s *search.Sort
+		switch {
+		case key.Field == "_score":
+			s = search.SortBy(search.DocumentScore())
+		case key.Field == "_id":
+			s = search.SortBy(search.Field("_id"))
+			byId = true
+		case ic.isSortable(key.Field):
+			s = search.SortBy(search.Field(sortField(key.Field)))
+		default:
+			s = search.SortBy(search.Field(key.Field))
+		}
+		if key.Desc {
+			s.Desc()
+		}
+		if key.MissingFirst {
+			s.MissingFirst()
+		}
+		order = append(order, s)
+	}
+	if !byId {
+		order = append(order, search.SortBy(search.Field("_id")))
+	}
+	return order
+}
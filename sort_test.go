@@ -0,0 +1,39 @@
+package sled
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchSortBy(t *testing.T) {
+	idx := newTestIndex(t, 3)
+	sc := newTestSearchConfig()
+	sc.SortBy = []SortKey{{Field: "brand", Desc: true}, {Field: "price"}}
+	res, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"doc-01", "doc-03", "doc-05", "doc-07", "doc-09", "doc-11", "doc-13", "doc-15", "doc-17", "doc-19",
+		"doc-00", "doc-02", "doc-04", "doc-06", "doc-08", "doc-10", "doc-12", "doc-14", "doc-16", "doc-18",
+	}, hitIds(res.Hits))
+
+	// paging keeps the sort order
+	sc.SortBy = []SortKey{{Field: "price", Desc: true}}
+	sc.Limit = 3
+	var paged []string
+	for {
+		res, err := idx.Search(context.Background(), "shirt", sc)
+		require.NoError(t, err)
+		paged = append(paged, hitIds(res.Hits)...)
+		if res.NextCursor == "" {
+			break
+		}
+		sc.SearchAfter = res.NextCursor
+	}
+	want := testDocIds(func(n int) bool { return true })
+	slices.Reverse(want)
+	assert.Equal(t, want, paged)
+}
@@ -0,0 +1,316 @@
+package sled
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/analysis"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"golang.org/x/sync/errgroup"
+)
+
+const structTag = "sled"
+
+// TypedIndex indexes structs of type T by their `sled` field tags, e.g.
+//
+//	type Product struct {
+//		Sku   string   `sled:"sku,keyword,id,store"`
+//		Title string   `sled:"title,text,store"`
+//		Price float64  `sled:"price,,store,sortable"`
+//		Tags  []string `sled:"tags,keyword,aggregatable"`
+//	}
+//
+// the tag is the field name followed by an optional FieldType and the options
+// id, store, sortable, aggregatable and noindex. without a type, it is inferred from the go type.
+// fields without a tag are ignored
+type TypedIndex[T any] struct {
+	*Index
+	fields []structField
+}
+
+type structField struct {
+	index []int
+	name  string
+	fm    FieldMapping
+}
+
+// NewTypedIndex maps the tagged fields of T in addition to IndexConfig.Mapping and uses the id field as IndexConfig.IdField
+func NewTypedIndex[T any](ic IndexConfig) (*TypedIndex[T], error) {
+	fields, idField, err := parseStructFields(reflect.TypeFor[T]())
+	if err != nil {
+		return nil, err
+	}
+	ic.IdField = idField
+	ic.Mapping = maps.Clone(ic.Mapping)
+	if ic.Mapping == nil {
+		ic.Mapping = Mapping{}
+	}
+	for _, f := range fields {
+		ic.Mapping[f.name] = f.fm
+	}
+	idx, err := NewIndex(ic)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedIndex[T]{Index: idx, fields: fields}, nil
+}
+
+func parseStructFields(t reflect.Type) (fields []structField, idField string, err error) {
+	if t.Kind() != reflect.Struct {
+		return nil, "", fmt.Errorf("typed index needs a struct, got %v", t)
+	}
+	for _, sf := range reflect.VisibleFields(t) {
+		tag, ok := sf.Tag.Lookup(structTag)
+		if !ok || tag == "-" || !sf.IsExported() {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		f := structField{index: sf.Index, name: parts[0]}
+		if f.name == "" {
+			f.name = sf.Name
+		}
+		if len(parts) > 1 {
+			f.fm.Type = FieldType(parts[1])
+		}
+		for _, opt := range lo.Compact(parts[min(2, len(parts)):]) {
+			switch opt {
+			case "id":
+				if idField != "" {
+					return nil, "", fmt.Errorf("%v has more than one id field", t)
+				}
+				idField = f.name
+			case "store":
+				f.fm.Store = true
+			case "sortable":
+				f.fm.Sortable = true
+			case "aggregatable":
+				f.fm.Aggregatable = true
+			case "noindex":
+				f.fm.Index = lo.ToPtr(false)
+			default:
+				return nil, "", fmt.Errorf("field %v of %v has unknown tag option %q", sf.Name, t, opt)
+			}
+		}
+		if f.fm.Type == "" {
+			if f.fm.Type, err = inferFieldType(sf.Type); err != nil {
+				return nil, "", fmt.Errorf("field %v of %v: %w", sf.Name, t, err)
+			}
+		}
+		fields = append(fields, f)
+	}
+	if idField == "" {
+		return nil, "", fmt.Errorf("%v has no field tagged as id", t)
+	}
+	return fields, idField, nil
+}
+
+func inferFieldType(t reflect.Type) (FieldType, error) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeFor[time.Time]():
+		return DateFieldType, nil
+	case t.Kind() == reflect.String:
+		return TextFieldType, nil
+	case t.Kind() == reflect.Bool:
+		return BoolFieldType, nil
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Float64:
+		return NumericFieldType, nil
+	}
+	return "", fmt.Errorf("cannot infer field type of %v", t)
+}
+
+func (ti *TypedIndex[T]) BatchInsert(items []T) error {
+	if err := ti.rlock(); err != nil {
+		return err
+	}
+	defer ti.mu.RUnlock()
+	start := time.Now()
+	defer func() {
+		slog.Debug("typed batch insert complete", "len", len(items), "duration", time.Since(start))
+	}()
+	as := ti.ic.AnalyzerConfig.GetAnalyzers()
+	docsByShardId := make(map[int][]*bluge.Document, ti.ic.ShardNum)
+	seen := make(map[string]bool, len(items))
+	for n, item := range items {
+		doc, err := ti.newDocument(item, as)
+		if err != nil {
+			return errors.WithMessagef(err, "failed for item at index %d", n)
+		}
+		id := string(doc.ID().Term())
+		// bluge does not handle document uniqueness
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		shardId := getShardId(ti.ic.ShardNum, id)
+		docsByShardId[shardId] = append(docsByShardId[shardId], doc)
+	}
+	eg := errgroup.Group{}
+	for shardId, docs := range docsByShardId {
+		eg.Go(func() error {
+			return ti.shards[shardId].BatchInsertDocuments(docs)
+		})
+	}
+	return eg.Wait()
+}
+
+// build the document from the tagged fields without converting the item to a map
+func (ti *TypedIndex[T]) newDocument(item T, as map[string]*analysis.Analyzer) (*bluge.Document, error) {
+	v := reflect.ValueOf(item)
+	var doc *bluge.Document
+	for _, f := range ti.fields {
+		if f.name == ti.ic.IdField {
+			fv, err := v.FieldByIndexErr(f.index)
+			if err != nil {
+				// the id is promoted from a nil embedded struct
+				return nil, fmt.Errorf("id field %q not found in %T: %w", f.name, item, err)
+			}
+			id, err := getDocumentId(f.name, fv)
+			if err != nil {
+				return nil, err
+			}
+			doc = bluge.NewDocument(id)
+			break
+		}
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("id field %q not found in %T", ti.ic.IdField, item)
+	}
+	types := map[string]string{}
+	for _, f := range ti.fields {
+		fv, err := v.FieldByIndexErr(f.index)
+		if err != nil {
+			// promoted from a nil embedded struct
+			continue
+		}
+		switch fv.Kind() {
+		case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+			if fv.IsNil() {
+				continue
+			}
+		}
+		fv = reflect.Indirect(fv)
+		a, ok := as[f.name]
+		if !ok {
+			a = as["*"]
+		}
+		if err := addMappedField(doc, f.name, fv.Interface(), f.fm, a, ti.ic, types); err != nil {
+			return nil, err
+		}
+	}
+	if err := addDocumentFields(doc, types, ti.ic, as); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Decode a hit into T from its returned stored fields; see SearchConfig.ReturnFields.
+// the id field is set from the hit id if it is not returned
+func (ti *TypedIndex[T]) Decode(hit Hit) (item T, err error) {
+	v := reflect.ValueOf(&item).Elem()
+	for _, f := range ti.fields {
+		values, ok := hit.Fields[f.name]
+		if !ok && f.name == ti.ic.IdField {
+			values, ok = []any{hit.Id}, true
+		}
+		if !ok || len(values) == 0 {
+			continue
+		}
+		fv, err := allocFieldByIndex(v, f.index)
+		if err == nil {
+			err = setFieldValue(fv, values, f.fm.Type)
+		}
+		if err != nil {
+			return item, fmt.Errorf("failed to decode field %q of hit %q: %w", f.name, hit.Id, err)
+		}
+	}
+	return item, nil
+}
+
+// DecodeHits of a search result in their order
+func (ti *TypedIndex[T]) DecodeHits(sr SearchResult) ([]T, error) {
+	items := make([]T, 0, len(sr.Hits))
+	for _, hit := range sr.Hits {
+		item, err := ti.Decode(hit)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// like reflect.Value.FieldByIndex, but allocates nil embedded structs on the way
+func allocFieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for n, i := range index {
+		if n > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return v, fmt.Errorf("cannot set embedded pointer to unexported struct %v", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, nil
+}
+
+func setFieldValue(dst reflect.Value, values []any, typ FieldType) error {
+	if dst.Kind() == reflect.Pointer {
+		dst.Set(reflect.New(dst.Type().Elem()))
+		dst = dst.Elem()
+	}
+	if typ == GeoPointFieldType {
+		return setGeoPoint(dst, values[0])
+	}
+	if dst.Kind() != reflect.Slice {
+		return setValue(dst, values[0])
+	}
+	s := reflect.MakeSlice(dst.Type(), len(values), len(values))
+	for n, value := range values {
+		if err := setValue(s.Index(n), value); err != nil {
+			return err
+		}
+	}
+	dst.Set(s)
+	return nil
+}
+
+func setValue(dst reflect.Value, value any) error {
+	v := reflect.ValueOf(value)
+	// strings are not converted from numbers
+	if !v.Type().ConvertibleTo(dst.Type()) || (dst.Kind() == reflect.String && v.Kind() != reflect.String) {
+		return fmt.Errorf("cannot set %T to %v", value, dst.Type())
+	}
+	dst.Set(v.Convert(dst.Type()))
+	return nil
+}
+
+// geo points are decoded into maps with lat and lon, [lon, lat] slices or "lat,lon" strings
+func setGeoPoint(dst reflect.Value, value any) error {
+	p, ok := value.(map[string]float64)
+	if !ok {
+		return fmt.Errorf("invalid geo point %v", value)
+	}
+	switch {
+	case dst.Kind() == reflect.Map:
+		return setValue(dst, p)
+	case dst.Kind() == reflect.Slice && slices.Contains([]reflect.Kind{reflect.Float32, reflect.Float64}, dst.Type().Elem().Kind()):
+		return setFieldValue(dst, []any{p["lon"], p["lat"]}, NumericFieldType)
+	case dst.Kind() == reflect.String:
+		dst.SetString(fmt.Sprintf("%v,%v", p["lat"], p["lon"]))
+		return nil
+	}
+	return fmt.Errorf("cannot set geo point to %v", dst.Type())
+}
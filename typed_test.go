@@ -0,0 +1,85 @@
+package sled
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/foomo/bluge-sled/analyzer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testProduct struct {
+	Sku      string             `sled:"sku,keyword,id,store"`
+	Title    string             `sled:"title,text,store"`
+	Price    int                `sled:"price,,store,sortable"`
+	Tags     []string           `sled:"tags,keyword,store,aggregatable"`
+	Released *time.Time         `sled:"released,,store"`
+	Location map[string]float64 `sled:"location,geo_point"`
+	Internal string
+}
+
+func TestTypedIndex(t *testing.T) {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	idx, err := NewTypedIndex[testProduct](NewDefaultIndexConfig("test", "", true, *ac))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = idx.Close() })
+	released := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	products := []testProduct{
+		{Sku: "a", Title: "red shirt", Price: 20, Tags: []string{"summer"}, Released: &released, Internal: "x"},
+		{Sku: "b", Title: "blue shirt", Price: 10, Location: map[string]float64{"lat": 1, "lon": 2}},
+	}
+	require.NoError(t, idx.BatchInsert(products))
+
+	sc := newTestSearchConfig()
+	sc.ReturnFields = []string{"sku", "title", "price", "tags", "released"}
+	sc.SortBy = []SortKey{{Field: "price"}}
+	res, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	items, err := idx.DecodeHits(res)
+	require.NoError(t, err)
+	products[0].Internal = ""
+	products[1].Location = nil
+	assert.Equal(t, []testProduct{products[1], products[0]}, items)
+
+	_, err = NewTypedIndex[struct {
+		Title string `sled:"title"`
+	}](IndexConfig{})
+	assert.Error(t, err, "missing id")
+}
+
+func TestTypedIndexInvalidId(t *testing.T) {
+	ac := analyzer.NewConfig(analyzer.English).WithTokenizer(analyzer.LetterTokenizer)
+	type Variant struct {
+		Sku string `sled:"sku,keyword,id,store"`
+	}
+	type embedded struct {
+		*Variant
+		Title string `sled:"title,text,store"`
+	}
+	idx, err := NewTypedIndex[embedded](NewDefaultIndexConfig("test", "", true, *ac))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = idx.Close() })
+	require.NoError(t, idx.BatchInsert([]embedded{{Variant: &Variant{Sku: "a"}, Title: "shirt"}}))
+	// the id is promoted from a nil embedded struct
+	assert.ErrorContains(t, idx.BatchInsert([]embedded{{Title: "shirt"}}), `id field "sku" not found`)
+	assert.ErrorContains(t, idx.BatchInsert([]embedded{{Variant: &Variant{}, Title: "shirt"}}), `id field "sku" is empty`)
+
+	sc := newTestSearchConfig()
+	sc.ReturnFields = []string{"sku", "title"}
+	res, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	items, err := idx.DecodeHits(res)
+	require.NoError(t, err)
+	assert.Equal(t, []embedded{{Variant: &Variant{Sku: "a"}, Title: "shirt"}}, items)
+
+	type pointer struct {
+		Sku *string `sled:"sku,keyword,id"`
+	}
+	pidx, err := NewTypedIndex[pointer](NewDefaultIndexConfig("test", "", true, *ac))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pidx.Close() })
+	assert.ErrorContains(t, pidx.BatchInsert([]pointer{{}}), `id field "sku" is nil`)
+	assert.NoError(t, pidx.BatchInsert([]pointer{{Sku: ptr("a")}}))
+}
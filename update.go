@@ -0,0 +1,148 @@
+package sled
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"golang.org/x/sync/errgroup"
+)
+
+// Transform returns the changed datum of a stored document or nil to leave it unchanged.
+// nested fields are named "parent.child". shards are updated in parallel,
+// so a transform must be safe for concurrent use
+type Transform func(datum map[string]any) (map[string]any, error)
+
+type UpdateConfig struct {
+	Filters   []Filter               // restrict the documents to update in addition to the query
+	BatchSize int                    // documents per shard batch; defaults to 1000
+	Progress  func(p UpdateProgress) // called after each written batch; calls are serialized
+}
+
+type UpdateProgress struct {
+	Matched int // documents passed to the transform so far
+	Updated int // documents written to the index
+}
+
+// UpdateByQuery transforms all stored documents matching the query and filters and writes the changes in batches per shard.
+// the query is analyzed with IndexConfig.AnalyzerConfig; an empty query matches all documents.
+// the transform is called concurrently, one goroutine per shard.
+// a failing transform or a cancelled context stops the update; batches written until then are kept.
+// works on indexes with IndexConfig.StoreFields "*" only
+func (i *Index) UpdateByQuery(ctx context.Context, query string, transform Transform, uc *UpdateConfig) (progress UpdateProgress, err error) {
+	if err := i.rlock(); err != nil {
+		return progress, err
+	}
+	defer i.mu.RUnlock()
+	if err := i.ic.requireAllStored("update by query"); err != nil {
+		return progress, err
+	}
+	if uc == nil {
+		uc = &UpdateConfig{}
+	}
+	batchSize := uc.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	start := time.Now()
+	defer func() {
+		slog.Debug("update by query complete", "query", query, "matched", progress.Matched, "updated", progress.Updated, "duration", time.Since(start))
+	}()
+	var mu sync.Mutex
+	update := func(matched, updated int) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress.Matched += matched
+		progress.Updated += updated
+		if updated > 0 && uc.Progress != nil {
+			uc.Progress(progress)
+		}
+	}
+	sc := &SearchConfig{AnalyzerConfig: i.ic.AnalyzerConfig, Filters: uc.Filters}
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, shard := range i.shards {
+		eg.Go(func() error {
+			return shard.UpdateByQuery(ctx, query, sc, transform, batchSize, update)
+		})
+	}
+	return progress, eg.Wait()
+}
+
+func (s *shard) UpdateByQuery(ctx context.Context, query string, sc *SearchConfig, transform Transform, batchSize int, update func(matched, updated int)) error {
+	r, err := s.w.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	q, err := newSearchQuery(query, sc, s.ic)
+	if err != nil {
+		return err
+	}
+	// the reader is a snapshot, so updated documents are not matched again
+	dmi, err := r.Search(ctx, bluge.NewAllMatches(q))
+	if err != nil {
+		return err
+	}
+	as := s.ic.AnalyzerConfig.GetAnalyzers()
+	b := bluge.NewBatch()
+	var matched, updated int
+	var ids []string
+	flush := func() error {
+		if updated > 0 {
+			s.mu.Lock()
+			err := s.write(b, ids)
+			s.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			b.Reset()
+			ids = ids[:0]
+		}
+		update(matched, updated)
+		matched, updated = 0, 0
+		return nil
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		match, err := dmi.Next()
+		if err != nil {
+			return err
+		}
+		if match == nil {
+			break
+		}
+		hit, err := decodeMatch(match)
+		if err != nil {
+			return err
+		}
+		matched++
+		datum, err := transform(hit.datum())
+		if err != nil {
+			return fmt.Errorf("failed to transform %q: %w", hit.Id, err)
+		}
+		if datum == nil {
+			continue
+		}
+		doc, _, err := newDocument(datum, s.ic, as)
+		if err != nil {
+			return fmt.Errorf("failed to update %q: %w", hit.Id, err)
+		}
+		if string(doc.ID().Term()) != hit.Id {
+			return fmt.Errorf("failed to update %q: transform must not change the id", hit.Id)
+		}
+		b.Update(doc.ID(), doc)
+		ids = append(ids, hit.Id)
+		updated++
+		if updated == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
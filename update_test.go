@@ -0,0 +1,39 @@
+package sled
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateByQuery(t *testing.T) {
+	idx := newTestIndex(t, 2)
+	var progress []UpdateProgress
+	res, err := idx.UpdateByQuery(context.Background(), "", func(datum map[string]any) (map[string]any, error) {
+		if datum["price"].(float64) >= 4 {
+			return nil, nil
+		}
+		datum["brand"] = "initech"
+		return datum, nil
+	}, &UpdateConfig{
+		Filters:   []Filter{{Term: &TermFilter{Field: "brand", Value: "acme"}}},
+		BatchSize: 1,
+		Progress:  func(p UpdateProgress) { progress = append(progress, p) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, UpdateProgress{Matched: 10, Updated: 2}, res)
+	assert.Len(t, progress, 2)
+
+	sc := newTestSearchConfig()
+	sc.Filters = []Filter{{Term: &TermFilter{Field: "brand", Value: "initech"}}}
+	sr, err := idx.Search(context.Background(), "shirt", sc)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"doc-00", "doc-02"}, hitIds(sr.Hits))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = idx.UpdateByQuery(ctx, "", func(datum map[string]any) (map[string]any, error) { return datum, nil }, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -7,7 +7,7 @@ import (
 	"os"
 	"reflect"
 	"slices"
-	"strconv"
+	"time"
 
 	"github.com/blugelabs/bluge"
 	"github.com/blugelabs/bluge/analysis"
@@ -17,117 +17,196 @@ import (
 	"github.com/samber/lo"
 )
 
+const (
+	keywordSuffix = "._keyword"
+	sortSuffix    = "._sort"
+	typesField    = "_types"
+)
+
+// types of stored values other than strings
+const (
+	numberType   = "number"
+	boolType     = "bool"
+	dateType     = "date"
+	geoPointType = "geo_point"
+)
+
 func getShardId(numShards int, id string) int {
 	return int(xxhash.Sum64String(id) % uint64(numShards))
 }
 
-func newBatchInsert(shardId int, data []map[string]any, idField string, storeFields []string, as map[string]*analysis.Analyzer) (b *index.Batch, fields []string, err error) {
+func newBatchInsert(shardId int, data []map[string]any, ic IndexConfig, as map[string]*analysis.Analyzer) (b *index.Batch, fields []string, err error) {
 	b = bluge.NewBatch()
 	slog.Debug("bulk inserting data", "shard", shardId, "length", len(data))
 	for i, datum := range data {
 		var doc *bluge.Document
-		doc, fields, err = newDocument(datum, idField, storeFields, as)
+		doc, fields, err = newDocument(datum, ic, as)
 		if err != nil {
 			// todo warn or quit?
 			return nil, nil, errors.WithMessagef(err, "failed for item at index %d", i)
 		}
-		b.Insert(doc)
+		// replace documents which are already indexed
+		b.Update(doc.ID(), doc)
 	}
 	return b, lo.Uniq(fields), nil
 }
 
-func newIndex(data []map[string]any, iw *bluge.Writer, idField string, storeFields []string, as map[string]*analysis.Analyzer) (fields []string, err error) {
+func newIndex(data []map[string]any, iw *bluge.Writer, ic IndexConfig, as map[string]*analysis.Analyzer) (fields []string, err error) {
 	for i, datum := range data {
 		var doc *bluge.Document
-		doc, fields, err = newDocument(datum, idField, storeFields, as)
+		doc, fields, err = newDocument(datum, ic, as)
 		if err != nil {
 			// todo warn or quit?
 			return nil, errors.WithMessagef(err, "failed for item at index %d", i)
 		}
-		if err := iw.Insert(doc); err != nil {
+		if err := iw.Update(doc.ID(), doc); err != nil {
 			return nil, err
 		}
 	}
 	return lo.Uniq(fields), nil
 }
 
-func newDocument(datum map[string]any, idField string, storeFields []string, as map[string]*analysis.Analyzer) (doc *bluge.Document, fields []string, err error) {
-	id, ok := datum[idField]
-	if !ok {
-		return nil, nil, fmt.Errorf("id field %q not found in data item", idField)
+func newDocument(datum map[string]any, ic IndexConfig, as map[string]*analysis.Analyzer) (doc *bluge.Document, fields []string, err error) {
+	id, err := getDatumId(ic.IdField, datum)
+	if err != nil {
+		return nil, nil, err
 	}
-	doc = bluge.NewDocument(fmt.Sprint(id))
+	doc = bluge.NewDocument(id)
+	types := map[string]string{}
 	for key, value := range datum {
 		a, ok := as[key]
 		if !ok {
 			a = as["*"]
 		}
-		added := addField(doc, key, value, a, storeFields)
-		if added == nil {
-			// todo handle field errors
-			continue
+		added, err := addField(doc, key, value, a, ic, types)
+		if err != nil {
+			return nil, nil, err
 		}
 		fields = append(fields, added...)
 	}
+	if err := addDocumentFields(doc, types, ic, as); err != nil {
+		return nil, nil, err
+	}
+	return doc, fields, nil
+}
+
+// the id of a data item, used for uniqueness, routing to a shard and the document itself
+func getDatumId(field string, datum map[string]any) (string, error) {
+	value, ok := datum[field]
+	if !ok {
+		return "", fmt.Errorf("id field %q not found in data item", field)
+	}
+	return getDocumentId(field, reflect.ValueOf(value))
+}
+
+// the id of a document from the value of its id field; pointers are dereferenced,
+// nil and "" are rejected, while other zero values like 0 or false are valid ids
+func getDocumentId(field string, v reflect.Value) (string, error) {
+	if !v.IsValid() || v.Kind() == reflect.Pointer && v.IsNil() {
+		return "", fmt.Errorf("id field %q is nil", field)
+	}
+	id := fmt.Sprint(reflect.Indirect(v).Interface())
+	if id == "" {
+		return "", fmt.Errorf("id field %q is empty", field)
+	}
+	return id, nil
+}
+
+// stored value types and the composite "_all" field, added once all other fields are set
+func addDocumentFields(doc *bluge.Document, types map[string]string, ic IndexConfig, as map[string]*analysis.Analyzer) error {
+	if len(types) > 0 {
+		// remember types of stored values, so hits can be decoded
+		b, err := json.Marshal(types)
+		if err != nil {
+			return err
+		}
+		doc.AddField(bluge.NewStoredOnlyField(typesField, b))
+	}
 	// add a composite field in order to search all fields if needed
-	field := bluge.NewCompositeFieldExcluding("_all", []string{"_id", idField})
+	excluded := []string{"_id", ic.IdField}
+	for _, f := range slices.Concat(ic.AggregateFields, ic.SortFields, lo.Keys(ic.Mapping)) {
+		excluded = append(excluded, keywordField(f), sortField(f))
+	}
+	for f, fm := range ic.Mapping {
+		if fm.Index != nil && !*fm.Index {
+			excluded = append(excluded, f)
+		}
+	}
+	field := bluge.NewCompositeFieldExcluding("_all", excluded)
 	a, ok := as["_all"]
 	if !ok {
 		a = as["*"]
 	}
 	field.WithAnalyzer(a)
+	if ic.TermPositions {
+		field.SearchTermPositions()
+	}
 	doc.AddField(field)
-	return doc, fields, nil
+	return nil
 }
 
-func addField(doc *bluge.Document, key string, value interface{}, a bluge.Analyzer, storeFields []string) (fields []string) {
+func addField(doc *bluge.Document, key string, value interface{}, a bluge.Analyzer, ic IndexConfig, types map[string]string) (fields []string, err error) {
 	if value == nil {
-		return nil
+		return nil, nil
 	}
+	if fm, ok := ic.Mapping[key]; ok {
+		if err := addMappedField(doc, key, value, fm, a, ic, types); err != nil {
+			return nil, err
+		}
+		return []string{key}, nil
+	}
+	// infer the type of unmapped fields
+	var fm FieldMapping
 	t := reflect.TypeOf(value)
-	switch t.Kind() {
-	case reflect.String:
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		fm.Type = DateFieldType
+	case t.Kind() == reflect.String:
 		if fmt.Sprint(value) == "" {
-			return nil
-		}
-		field := bluge.NewTextField(key, fmt.Sprint(value))
-		addTermField(doc, field, a, storeFields)
-		fields = append(fields, key)
-	case reflect.Int:
-		field := bluge.NewNumericField(key, value.(float64))
-		addTermField(doc, field, a, storeFields)
-		fields = append(fields, key)
-	case reflect.Float64:
-		field := bluge.NewNumericField(key, value.(float64))
-		addTermField(doc, field, a, storeFields)
-		fields = append(fields, key)
-	case reflect.Bool:
-		field := bluge.NewKeywordField(key, strconv.FormatBool(value.(bool)))
-		addTermField(doc, field, a, storeFields)
-		fields = append(fields, key)
-	case reflect.Map:
+			return nil, nil
+		}
+		fm.Type = TextFieldType
+	case t.Kind() == reflect.Bool:
+		fm.Type = BoolFieldType
+	case t.Kind() == reflect.Map:
 		vm, ok := value.(map[string]any)
 		if !ok {
 			// todo handle other than map[string]any
-			return nil
+			return nil, nil
 		}
 		for k, v := range vm {
-			fields = append(fields, addField(doc, fmt.Sprintf("%v.%v", key, k), v, a, storeFields)...)
+			added, err := addField(doc, fmt.Sprintf("%v.%v", key, k), v, a, ic, types)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, added...)
 		}
-	case reflect.Slice:
-		vs, ok := value.([]any)
-		if !ok {
-			return nil
+		return fields, nil
+	case t.Kind() == reflect.Slice:
+		// elements are added as multiple values of the same field
+		vs := reflect.ValueOf(value)
+		for i := range vs.Len() {
+			added, err := addField(doc, key, vs.Index(i).Interface(), a, ic, types)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, added...)
 		}
-		for i, v := range vs {
-			fields = append(fields, addField(doc, fmt.Sprintf("%v.[%v]", key, i), v, a, storeFields)...)
+		return fields, nil
+	default:
+		if _, ok := toFloat64(value); !ok {
+			return nil, nil
 		}
+		fm.Type = NumericFieldType
 	}
-	return fields
+	if err := addMappedField(doc, key, value, fm, a, ic, types); err != nil {
+		return nil, err
+	}
+	return []string{key}, nil
 }
 
-func addTermField(d *bluge.Document, f *bluge.TermField, a bluge.Analyzer, storeFields []string) {
-	if slices.Contains(storeFields, f.Name()) || slices.Contains(storeFields, "*") {
+func addTermField(d *bluge.Document, f *bluge.TermField, a bluge.Analyzer, ic IndexConfig) {
+	if ic.isStored(f.Name()) {
 		f.StoreValue()
 	}
 	if a != nil {
@@ -136,6 +215,65 @@ func addTermField(d *bluge.Document, f *bluge.TermField, a bluge.Analyzer, store
 	d.AddField(f)
 }
 
+func addStoredType(types map[string]string, key string, ic IndexConfig, typ string) {
+	if ic.isStored(key) {
+		types[key] = typ
+	}
+}
+
+// decode a stored value by the type it was indexed with; strings by default
+func decodeStoredValue(value []byte, typ string) any {
+	switch typ {
+	case numberType:
+		if f, err := bluge.DecodeNumericFloat64(value); err == nil {
+			return f
+		}
+	case dateType:
+		if dt, err := bluge.DecodeDateTime(value); err == nil {
+			return dt
+		}
+	case boolType:
+		return string(value) == "true"
+	case geoPointType:
+		if lon, lat, err := bluge.DecodeGeoLonLat(value); err == nil {
+			return map[string]float64{"lat": lat, "lon": lon}
+		}
+	}
+	return string(value)
+}
+
+// typed values per field and, for compatibility, the first value of each field as string
+func decodeStoredFields(stored map[string][][]byte, types map[string]string) (values map[string]string, fields map[string][]any) {
+	values = make(map[string]string, len(stored))
+	fields = make(map[string][]any, len(stored))
+	for field, vs := range stored {
+		for _, v := range vs {
+			fields[field] = append(fields[field], decodeStoredValue(v, types[field]))
+		}
+		values[field] = fmt.Sprint(fields[field][0])
+	}
+	return values, fields
+}
+
+// untokenized copy of a field used for terms facets
+func keywordField(field string) string {
+	return field + keywordSuffix
+}
+
+func addKeywordField(d *bluge.Document, key, value string, ic IndexConfig) {
+	if !ic.isAggregatable(key) {
+		return
+	}
+	d.AddField(bluge.NewKeywordField(keywordField(key), value).Aggregatable())
+}
+
+func addSortField(d *bluge.Document, key string, ic IndexConfig, newField func(name string) *bluge.TermField) {
+	if !ic.isSortable(key) {
+		return
+	}
+	d.AddField(newField(sortField(key)).Sortable())
+}
+
 func loadData(path string) ([]map[string]any, error) {
 	f, err := os.Open(path)
 	if err != nil {